@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	zplorama "github.com/jasonbot/zpl-o-rama/v1"
+)
+
+func main() {
+	var listenAddress string
+	var controlPort int
+	var labelDir string
+	var labelTime time.Duration
+	var paperOut bool
+	var headOpen bool
+
+	flag.StringVar(&listenAddress, "listen", ":9100", "Address to accept ZPL on")
+	flag.IntVar(&controlPort, "controlport", 9180, "Port for the HTTP API that simulates printer problems (GET/POST /state)")
+	flag.StringVar(&labelDir, "labeldir", "labels", "Directory to save printed labels to (empty to not save them)")
+	flag.DurationVar(&labelTime, "labeltime", 1*time.Second, "How long each label takes to print")
+	flag.BoolVar(&paperOut, "paperout", false, "Start out of paper")
+	flag.BoolVar(&headOpen, "headopen", false, "Start with the print head open")
+	flag.Parse()
+
+	zplorama.RunFakePrinter(listenAddress, controlPort, labelDir, labelTime, paperOut, headOpen)
+}
@@ -0,0 +1,411 @@
+package zplorama
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/jasonbot/zpl-o-rama/v1/zpl"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	multiqrcode "github.com/makiuchi-d/gozxing/multi/qrcode"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// How a barcode on the label fared against the ZPL that printed it
+type barcodeCheckStatus string
+
+const (
+	barcodePassed     barcodeCheckStatus = "PASS"
+	barcodeFailed                        = "FAIL"
+	barcodeUnverified                    = "UNVERIFIED"
+)
+
+// How deep to look for more barcodes around one that was found
+const barcodeSearchDepth = 4
+
+// Barcodes decoded within this many pixels of each other are the same one
+const barcodeSameDistance = 20.0
+
+// How far to step when measuring a 1D barcode, and the smallest leftover
+// space worth looking in for another one
+const (
+	barcodeStripSize     = 4
+	barcodeMinimumRegion = 20
+)
+
+type barcodeCheck struct {
+	Command  string             `json:"command"`
+	Format   string             `json:"format"`
+	Expected string             `json:"expected"`
+	Decoded  string             `json:"decoded"`
+	Status   barcodeCheckStatus `json:"status"`
+	Message  string             `json:"message,omitempty"`
+}
+
+// What each ZPL barcode command prints, and whether the printer tacks on a
+// check digit the scanner will read back
+type barcodeSymbology struct {
+	format     gozxing.BarcodeFormat
+	checkDigit bool
+	decodable  bool
+}
+
+var barcodeSymbologies = map[string]barcodeSymbology{
+	"B2": {gozxing.BarcodeFormat_ITF, false, true},
+	"B3": {gozxing.BarcodeFormat_CODE_39, false, true},
+	"B7": {gozxing.BarcodeFormat_PDF_417, false, false},
+	"B8": {gozxing.BarcodeFormat_EAN_8, true, true},
+	"B9": {gozxing.BarcodeFormat_UPC_E, true, true},
+	"BA": {gozxing.BarcodeFormat_CODE_93, false, true},
+	"BC": {gozxing.BarcodeFormat_CODE_128, false, true},
+	"BE": {gozxing.BarcodeFormat_EAN_13, true, true},
+	"BK": {gozxing.BarcodeFormat_CODABAR, false, true},
+	"BQ": {gozxing.BarcodeFormat_QR_CODE, false, true},
+	"BU": {gozxing.BarcodeFormat_UPC_A, true, true},
+	"BX": {gozxing.BarcodeFormat_DATA_MATRIX, false, true},
+	"BO": {gozxing.BarcodeFormat_AZTEC, false, false},
+	"BD": {gozxing.BarcodeFormat_MAXICODE, false, false},
+}
+
+// A barcode field in a ZPL label
+type barcodeField struct {
+	command string
+	params  string
+	data    string
+}
+
+// Find the ^B* barcode fields in some ZPL along with their ^FD data
+func zplBarcodeFields(source string) []barcodeField {
+	var fields []barcodeField
+
+	for _, label := range zpl.Parse(source).Labels {
+		for _, field := range label.Fields {
+			barcode := field.Barcode()
+
+			if barcode == nil || field.End == nil {
+				continue
+			}
+
+			fields = append(fields, barcodeField{command: barcode.Name, params: barcode.Params, data: field.Text})
+		}
+	}
+
+	return fields
+}
+
+// What a scanner should read back from a field's data
+func (field barcodeField) expectedText() string {
+	data := field.data
+
+	switch field.command {
+	case "BQ":
+		_, data = zpl.QRCodeData(data)
+	case "BC":
+		data = zpl.Code128Text(data)
+	}
+
+	return data
+}
+
+// Whether the printer adds a check digit that isn't in the field data
+func (field barcodeField) hasCheckDigit() bool {
+	if field.command == "B3" {
+		params := strings.Split(field.params, ",")
+		return len(params) > 1 && strings.ToUpper(strings.TrimSpace(params[1])) == "Y"
+	}
+
+	return barcodeSymbologies[field.command].checkDigit
+}
+
+// Whether what the scanner read is what the ZPL asked for
+func (field barcodeField) matches(decoded string) bool {
+	expected := field.expectedText()
+
+	if decoded == expected {
+		return true
+	}
+
+	return field.hasCheckDigit() && len(decoded) == len(expected)+1 && strings.HasPrefix(decoded, expected)
+}
+
+type decodedBarcode struct {
+	format gozxing.BarcodeFormat
+	text   string
+	x, y   float64
+}
+
+func addDecodedBarcode(found []decodedBarcode, barcode decodedBarcode) []decodedBarcode {
+	for _, existing := range found {
+		if existing.format == barcode.format && existing.text == barcode.text &&
+			math.Hypot(existing.x-barcode.x, existing.y-barcode.y) < barcodeSameDistance {
+			return found
+		}
+	}
+
+	return append(found, barcode)
+}
+
+// The box around a barcode's result points
+func resultBounds(points []gozxing.ResultPoint) image.Rectangle {
+	var bounds image.Rectangle
+
+	for index, point := range points {
+		corner := image.Rect(int(point.GetX()), int(point.GetY()), int(point.GetX())+1, int(point.GetY())+1)
+
+		if index == 0 {
+			bounds = corner
+		} else {
+			bounds = bounds.Union(corner)
+		}
+	}
+
+	return bounds
+}
+
+// A 1D barcode is found on a single scan line; step outward from it while
+// the same barcode still reads to find out how tall (or wide) it really is
+func extendLinearBarcode(bitmap *gozxing.BinaryBitmap, reader gozxing.Reader, hints map[gozxing.DecodeHintType]interface{}, text string, box image.Rectangle) image.Rectangle {
+	imageBounds := image.Rect(0, 0, bitmap.GetWidth(), bitmap.GetHeight())
+	horizontal := box.Dx() >= box.Dy()
+
+	// Leave the reader some quiet zone either side
+	margin := 10 + box.Dx()/10
+	if !horizontal {
+		margin = 10 + box.Dy()/10
+	}
+
+	readsAt := func(offset int) bool {
+		var strip image.Rectangle
+
+		if horizontal {
+			strip = image.Rect(box.Min.X-margin, box.Min.Y+offset, box.Max.X+margin, box.Min.Y+offset+barcodeStripSize)
+		} else {
+			strip = image.Rect(box.Min.X+offset, box.Min.Y-margin, box.Min.X+offset+barcodeStripSize, box.Max.Y+margin)
+		}
+		strip = strip.Intersect(imageBounds)
+
+		if strip.Empty() {
+			return false
+		}
+
+		cropped, err := bitmap.Crop(strip.Min.X, strip.Min.Y, strip.Dx(), strip.Dy())
+
+		if err != nil {
+			return false
+		}
+
+		result, err := reader.Decode(cropped, hints)
+
+		return err == nil && result.GetText() == text
+	}
+
+	before, after := 0, 0
+	for readsAt(before - barcodeStripSize) {
+		before -= barcodeStripSize
+	}
+	for readsAt(after + barcodeStripSize) {
+		after += barcodeStripSize
+	}
+
+	if horizontal {
+		return image.Rect(box.Min.X, box.Min.Y+before, box.Max.X, box.Max.Y+after+barcodeStripSize).Intersect(imageBounds)
+	}
+
+	return image.Rect(box.Min.X+before, box.Min.Y, box.Max.X+after+barcodeStripSize, box.Max.Y).Intersect(imageBounds)
+}
+
+// Decode one barcode, then look for more in the space left, right, above and
+// below it (this is how ZXing's GenericMultipleBarcodeReader does it)
+func findBarcodes(bitmap *gozxing.BinaryBitmap, reader gozxing.Reader, offset image.Point, depth int, found []decodedBarcode) []decodedBarcode {
+	if depth > barcodeSearchDepth {
+		return found
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	result, err := reader.Decode(bitmap, hints)
+
+	if err != nil {
+		return found
+	}
+
+	points := result.GetResultPoints()
+
+	if len(points) == 0 {
+		return addDecodedBarcode(found, decodedBarcode{format: result.GetBarcodeFormat(), text: result.GetText()})
+	}
+
+	box := resultBounds(points)
+
+	if _, matrix := reader.(*datamatrix.DataMatrixReader); !matrix {
+		box = extendLinearBarcode(bitmap, reader, hints, result.GetText(), box)
+	}
+
+	found = addDecodedBarcode(found, decodedBarcode{
+		format: result.GetBarcodeFormat(),
+		text:   result.GetText(),
+		x:      float64(offset.X) + float64(box.Min.X+box.Max.X)/2,
+		y:      float64(offset.Y) + float64(box.Min.Y+box.Max.Y)/2,
+	})
+
+	width, height := bitmap.GetWidth(), bitmap.GetHeight()
+	regions := []image.Rectangle{
+		image.Rect(0, 0, box.Min.X, height),
+		image.Rect(0, 0, width, box.Min.Y),
+		image.Rect(box.Max.X, 0, width, height),
+		image.Rect(0, box.Max.Y, width, height),
+	}
+
+	for _, region := range regions {
+		if region.Dx() < barcodeMinimumRegion || region.Dy() < barcodeMinimumRegion {
+			continue
+		}
+
+		cropped, err := bitmap.Crop(region.Min.X, region.Min.Y, region.Dx(), region.Dy())
+
+		if err == nil {
+			found = findBarcodes(cropped, reader, offset.Add(region.Min), depth+1, found)
+		}
+	}
+
+	return found
+}
+
+// Every barcode that can be read out of a picture
+func decodeBarcodes(picture image.Image) ([]decodedBarcode, error) {
+	bitmap, err := gozxing.NewBinaryBitmap(gozxing.NewHybridBinarizer(gozxing.NewLuminanceSourceFromImage(picture)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	readers := []gozxing.Reader{
+		oned.NewCode128Reader(),
+		oned.NewCode39Reader(),
+		oned.NewCode93Reader(),
+		oned.NewMultiFormatUPCEANReader(hints),
+		oned.NewITFReader(),
+		oned.NewCodaBarReader(),
+		datamatrix.NewDataMatrixReader(),
+	}
+
+	var found []decodedBarcode
+
+	// Each kind gets its own search so one kind of barcode can't use up the
+	// search depth before the others are looked for
+	for _, reader := range readers {
+		found = findBarcodes(bitmap, reader, image.Point{}, 0, found)
+	}
+
+	// The Data Matrix detector only looks out from the middle of the picture,
+	// so go looking for them in overlapping windows too
+	matrixReader := datamatrix.NewDataMatrixReader()
+	for _, divisions := range []int{2, 4} {
+		windowWidth, windowHeight := bitmap.GetWidth()/divisions, bitmap.GetHeight()/divisions
+
+		for top := 0; top+windowHeight <= bitmap.GetHeight(); top += windowHeight / 2 {
+			for left := 0; left+windowWidth <= bitmap.GetWidth(); left += windowWidth / 2 {
+				window, err := bitmap.Crop(left, top, windowWidth, windowHeight)
+
+				if err == nil {
+					found = findBarcodes(window, matrixReader, image.Pt(left, top), barcodeSearchDepth, found)
+				}
+			}
+		}
+	}
+
+	qrCodes, _ := multiqrcode.NewQRCodeMultiReader().DecodeMultiple(bitmap, hints)
+	for _, result := range qrCodes {
+		box := resultBounds(result.GetResultPoints())
+		found = addDecodedBarcode(found, decodedBarcode{
+			format: result.GetBarcodeFormat(),
+			text:   result.GetText(),
+			x:      float64(box.Min.X+box.Max.X) / 2,
+			y:      float64(box.Min.Y+box.Max.Y) / 2,
+		})
+	}
+
+	return found, nil
+}
+
+// Check the barcodes in a picture of a label against the ZPL that printed it
+func verifyBarcodes(zpl string, imageBytes []byte) ([]barcodeCheck, error) {
+	fields := zplBarcodeFields(zpl)
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	picture, err := imaging.Decode(bytes.NewBuffer(imageBytes))
+
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := decodeBarcodes(picture)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]barcodeCheck, len(fields))
+	used := make([]bool, len(found))
+
+	for index, field := range fields {
+		symbology, known := barcodeSymbologies[field.command]
+		checks[index] = barcodeCheck{Command: "^" + field.command, Expected: field.expectedText(), Status: barcodeFailed}
+
+		if !known {
+			checks[index].Status = barcodeUnverified
+			checks[index].Message = "Unknown barcode type"
+			continue
+		}
+
+		checks[index].Format = symbology.format.String()
+
+		if !symbology.decodable {
+			checks[index].Status = barcodeUnverified
+			checks[index].Message = fmt.Sprintf("Not verified: there's no %v decoder to read it back with", symbology.format)
+		}
+	}
+
+	// Exact matches first, so a misprint doesn't steal a good barcode's match
+	for index, field := range fields {
+		if checks[index].Status != barcodeFailed {
+			continue
+		}
+
+		for foundIndex, barcode := range found {
+			if !used[foundIndex] && barcode.format == barcodeSymbologies[field.command].format && field.matches(barcode.text) {
+				used[foundIndex] = true
+				checks[index].Decoded = barcode.text
+				checks[index].Status = barcodePassed
+				break
+			}
+		}
+	}
+
+	// Then whatever's left of the same kind was meant to be one of the rest
+	for index, field := range fields {
+		if checks[index].Status != barcodeFailed {
+			continue
+		}
+
+		checks[index].Message = "Barcode not found in picture"
+
+		for foundIndex, barcode := range found {
+			if !used[foundIndex] && barcode.format == barcodeSymbologies[field.command].format {
+				used[foundIndex] = true
+				checks[index].Decoded = barcode.text
+				checks[index].Message = "Barcode does not match field data"
+				break
+			}
+		}
+	}
+
+	return checks, nil
+}
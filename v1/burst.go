@@ -0,0 +1,247 @@
+package zplorama
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/disintegration/imaging"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultBurstInterval  = 500 * time.Millisecond
+	defaultBurstMaxFrames = 60
+	// Width of the animated time-lapse; GIFs get big fast
+	timelapseWidth = 480
+)
+
+// How often a camera takes pictures while a label prints, and how many at most
+type burstSettings struct {
+	interval  time.Duration
+	maxFrames int
+}
+
+func newBurstSettings(config cameraConfig) burstSettings {
+	settings := burstSettings{
+		interval:  parseDurationOr(config.BurstInterval, defaultBurstInterval),
+		maxFrames: config.BurstMaxFrames,
+	}
+
+	if settings.maxFrames <= 0 {
+		settings.maxFrames = defaultBurstMaxFrames
+	}
+
+	return settings
+}
+
+// A picture taken part way through printing
+type capturedFrame struct {
+	offset time.Duration
+	png    []byte
+}
+
+// Pictures being taken in the background while a label prints
+type burstCapture struct {
+	started time.Time
+	stop    chan struct{}
+	done    sync.WaitGroup
+	frames  []capturedFrame
+	errors  int
+}
+
+// Start taking pictures every so often until finish is called
+func (camera *printerCamera) startBurst() *burstCapture {
+	burst := &burstCapture{started: time.Now(), stop: make(chan struct{})}
+	burst.done.Add(1)
+
+	go func() {
+		defer burst.done.Done()
+
+		for len(burst.frames) < camera.burst.maxFrames {
+			taken := time.Now()
+			picture, err := camera.camera.Capture()
+
+			if err != nil {
+				burst.errors++
+			} else {
+				burst.frames = append(burst.frames, capturedFrame{offset: taken.Sub(burst.started), png: picture})
+			}
+
+			select {
+			case <-burst.stop:
+				return
+			case <-time.After(time.Until(taken.Add(camera.burst.interval))):
+			}
+		}
+	}()
+
+	return burst
+}
+
+// Stop taking pictures and hand back the ones taken
+func (burst *burstCapture) finish() []capturedFrame {
+	if burst == nil {
+		return nil
+	}
+
+	close(burst.stop)
+	burst.done.Wait()
+
+	return burst.frames
+}
+
+// A job's time-lapse: the individual frames and an animation of them
+type jobFrames struct {
+	Jobid        string        `json:"jobid"`
+	Frames       []storedFrame `json:"frames"`
+	AnimationB64 string        `json:"animation"`
+}
+
+type storedFrame struct {
+	Offset   int64  `json:"offset_ms"`
+	ImageB64 string `json:"image"`
+}
+
+// Make this struct boltable
+func (*jobFrames) Table() string {
+	return framesTable
+}
+
+func (frames *jobFrames) Key() string {
+	return frames.Jobid
+}
+
+// Animate the frames as a GIF, each one shown for as long as it really took
+func encodeTimelapse(frames []capturedFrame) ([]byte, error) {
+	animation := &gif.GIF{}
+
+	for index, frame := range frames {
+		picture, err := imaging.Decode(bytes.NewBuffer(frame.png))
+
+		if err != nil {
+			return nil, err
+		}
+
+		picture = imaging.Resize(picture, timelapseWidth, 0, imaging.Box)
+
+		paletted := image.NewPaletted(picture.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, picture.Bounds(), picture, image.Point{})
+
+		// Hold the last frame for a couple of seconds before it loops
+		delay := 200
+		if index+1 < len(frames) {
+			delay = int((frames[index+1].offset - frame.offset) / (10 * time.Millisecond))
+		}
+
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, delay)
+	}
+
+	var encoded bytes.Buffer
+	err := gif.EncodeAll(&encoded, animation)
+
+	return encoded.Bytes(), err
+}
+
+// Store the pictures taken while a job printed, with the final picture on the end
+func saveBurst(db *bolt.DB, status *printJobStatus, burst *burstCapture, frames []capturedFrame, finalPicture []byte) {
+	if finalPicture != nil {
+		frames = append(frames, capturedFrame{offset: time.Since(burst.started), png: finalPicture})
+	}
+
+	if burst.errors > 0 {
+		status.Log = append(status.Log, fmt.Sprintf("Missed %v time-lapse frames", burst.errors))
+	}
+
+	if len(frames) == 0 {
+		return
+	}
+
+	record := jobFrames{Jobid: status.Jobid}
+	preview, err := renditionNamed(previewRendition)
+
+	for _, frame := range frames {
+		var data []byte
+
+		if err == nil {
+			data, err = preview.render(frame.png)
+		}
+
+		if err != nil {
+			break
+		}
+
+		record.Frames = append(record.Frames, storedFrame{
+			Offset:   frame.offset.Milliseconds(),
+			ImageB64: base64.StdEncoding.EncodeToString(data),
+		})
+		status.Frames = append(status.Frames, frame.offset.Milliseconds())
+	}
+
+	if err == nil {
+		var animation []byte
+		animation, err = encodeTimelapse(frames)
+		record.AnimationB64 = base64.StdEncoding.EncodeToString(animation)
+	}
+
+	if err == nil {
+		err = PutRecord(db, &record)
+	}
+
+	if err != nil {
+		status.Frames = nil
+		status.Log = append(status.Log, fmt.Sprintf("Could not save time-lapse: %v", err))
+	} else {
+		status.Log = append(status.Log, fmt.Sprintf("Saved a %v frame time-lapse", len(record.Frames)))
+	}
+}
+
+// GET /job/:id/timelapse.gif
+func getJobTimelapse(database *bolt.DB) func(echo.Context) error {
+	return func(c echo.Context) error {
+		frames := jobFrames{Jobid: c.Param("id")}
+
+		if GetRecord(database, &frames) != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job has no time-lapse"})
+		}
+
+		data, _ := base64.StdEncoding.DecodeString(frames.AnimationB64)
+
+		c.Response().Header().Set("Cache-Control", "max-age=31536000")
+
+		return c.Blob(http.StatusOK, "image/gif", data)
+	}
+}
+
+// GET /job/:id/frames/:frame, numbered from 0
+func getJobFrame(database *bolt.DB) func(echo.Context) error {
+	return func(c echo.Context) error {
+		frames := jobFrames{Jobid: c.Param("id")}
+
+		if GetRecord(database, &frames) != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job has no time-lapse"})
+		}
+
+		index, err := strconv.Atoi(c.Param("frame"))
+
+		if err != nil || index < 0 || index >= len(frames.Frames) {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "No such frame"})
+		}
+
+		data, _ := base64.StdEncoding.DecodeString(frames.Frames[index].ImageB64)
+
+		c.Response().Header().Set("Cache-Control", "max-age=31536000")
+
+		return c.Blob(http.StatusOK, http.DetectContentType(data), data)
+	}
+}
@@ -0,0 +1,497 @@
+package zplorama
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/disintegration/imaging"
+	"github.com/jasonbot/zpl-o-rama/v1/zpl"
+	"github.com/labstack/echo"
+)
+
+const (
+	// Calibration marks are filled squares in each corner of the label; the
+	// top left one is bigger so it's clear which way up the label is
+	calibrationMarkSize   = 40
+	calibrationAnchorSize = 64
+	// Label edge to the middle of each mark, in dots
+	calibrationMarkCenter = 56
+	// Width marks are looked for at
+	calibrationDetectWidth = 800
+
+	mmPerInch = 25.4
+)
+
+var errNoCalibrationMarks = errors.New("Could not find the four calibration marks in the picture")
+
+// A calibration mark: where it was printed and where the camera saw it
+type calibrationPoint struct {
+	DotX   float64 `json:"dot_x"`
+	DotY   float64 `json:"dot_y"`
+	PixelX float64 `json:"pixel_x"`
+	PixelY float64 `json:"pixel_y"`
+}
+
+// How label dots line up with a camera's (cropped) pictures for one printer
+type calibrationRecord struct {
+	Printer     string             `json:"printer"`
+	Camera      string             `json:"camera"`
+	Jobid       string             `json:"jobid"`
+	Media       string             `json:"media"`
+	DPI         int                `json:"dpi"`
+	Points      []calibrationPoint `json:"points"`
+	ImageWidth  int                `json:"image_width"`
+	ImageHeight int                `json:"image_height"`
+	Created     string             `json:"created"`
+}
+
+// Make this struct boltable
+func (*calibrationRecord) Table() string {
+	return calibrationTable
+}
+
+func (calibration *calibrationRecord) Key() string {
+	return calibration.Printer + "/" + calibration.Camera
+}
+
+// A ^FO or ^FT on the job's label and where it landed in the picture
+type fieldOrigin struct {
+	Command string  `json:"command"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Data    string  `json:"data"`
+	PixelX  float64 `json:"pixel_x"`
+	PixelY  float64 `json:"pixel_y"`
+}
+
+// The label's size as measured in the picture against the size the ZPL asked for
+type labelMeasurement struct {
+	WidthMM          float64 `json:"width_mm"`
+	LengthMM         float64 `json:"length_mm"`
+	ExpectedWidthMM  float64 `json:"expected_width_mm"`
+	ExpectedLengthMM float64 `json:"expected_length_mm"`
+}
+
+// ZPL for a label with a calibration mark in each corner
+func calibrationPattern(width, length int) string {
+	lines := []string{"^XA", "^LH0,0", "^PON"}
+
+	corners := [][2]int{
+		{calibrationMarkCenter, calibrationMarkCenter},
+		{width - calibrationMarkCenter, calibrationMarkCenter},
+		{width - calibrationMarkCenter, length - calibrationMarkCenter},
+		{calibrationMarkCenter, length - calibrationMarkCenter},
+	}
+
+	for index, corner := range corners {
+		size := calibrationMarkSize
+		if index == 0 {
+			size = calibrationAnchorSize
+		}
+
+		lines = append(lines, fmt.Sprintf("^FO%v,%v^GB%v,%v,%v^FS", corner[0]-size/2, corner[1]-size/2, size, size, size))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("^FO0,%v^FB%v,1,0,C^A0N,30,30^FDZPL-O-Rama camera calibration^FS", length/2-15, width),
+		"^XZ")
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Find the calibration marks in a picture: top left (the big one) first, then
+// clockwise around the label
+func findCalibrationMarks(picture []byte) ([]framePoint, int, int, error) {
+	decoded, err := imaging.Decode(bytes.NewBuffer(picture))
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	gray, threshold, scale := detectionImage(decoded, calibrationDetectWidth, 0.5)
+	width, height := gray.Bounds().Dx(), gray.Bounds().Dy()
+
+	dark := func(x, y int) bool {
+		return gray.Pix[y*gray.Stride+x*4] <= threshold
+	}
+
+	type mark struct {
+		center framePoint
+		area   int
+	}
+
+	var marks []mark
+
+	for _, blob := range connectedBlobs(width, height, dark) {
+		// Too small to be anything, or big enough to be the desk
+		if len(blob) < 12 || len(blob) > width*height/20 {
+			continue
+		}
+
+		left, top, right, bottom := width, height, 0, 0
+		sumX, sumY := 0, 0
+
+		for _, index := range blob {
+			x, y := index%width, index/width
+			sumX += x
+			sumY += y
+
+			if x < left {
+				left = x
+			}
+			if x > right {
+				right = x
+			}
+			if y < top {
+				top = y
+			}
+			if y > bottom {
+				bottom = y
+			}
+		}
+
+		boxWidth, boxHeight := right-left+1, bottom-top+1
+		aspect := float64(boxWidth) / float64(boxHeight)
+		fill := float64(len(blob)) / float64(boxWidth*boxHeight)
+
+		// Marks are solid and about square even when seen at an angle; text isn't
+		if aspect < 0.6 || aspect > 1.67 || fill < 0.7 {
+			continue
+		}
+
+		marks = append(marks, mark{
+			center: framePoint{
+				X: (float64(sumX)/float64(len(blob)) + 0.5) * scale,
+				Y: (float64(sumY)/float64(len(blob)) + 0.5) * scale,
+			},
+			area: len(blob),
+		})
+	}
+
+	if len(marks) < 4 {
+		return nil, 0, 0, errNoCalibrationMarks
+	}
+
+	sort.Slice(marks, func(i, j int) bool {
+		return marks[i].area > marks[j].area
+	})
+	marks = marks[:4]
+
+	if float64(marks[0].area) < 1.5*float64(marks[1].area) {
+		return nil, 0, 0, errors.New("Could not tell which calibration mark is the top left one")
+	}
+
+	// Going round the middle by angle is clockwise, since y is down
+	var middle framePoint
+	for _, found := range marks {
+		middle.X += found.center.X / 4
+		middle.Y += found.center.Y / 4
+	}
+
+	angle := func(point framePoint) float64 {
+		return math.Atan2(point.Y-middle.Y, point.X-middle.X)
+	}
+
+	anchor := angle(marks[0].center)
+	sort.Slice(marks, func(i, j int) bool {
+		return math.Mod(angle(marks[i].center)-anchor+4*math.Pi, 2*math.Pi) < math.Mod(angle(marks[j].center)-anchor+4*math.Pi, 2*math.Pi)
+	})
+
+	centers := make([]framePoint, 0, 4)
+	for _, found := range marks {
+		centers = append(centers, found.center)
+	}
+
+	bounds := decoded.Bounds()
+
+	return centers, bounds.Dx(), bounds.Dy(), nil
+}
+
+// Dots on the label to pixels in a picture of some size, and back
+type dotMapping struct {
+	left, top, width, length float64
+	scaleX, scaleY           float64
+	quad                     squareToQuad
+}
+
+// The mapping for a picture the given size; pictures scaled since calibrating are scaled to match
+func (calibration *calibrationRecord) mapping(imageWidth, imageHeight int) (dotMapping, error) {
+	if len(calibration.Points) != 4 || calibration.ImageWidth < 1 || calibration.ImageHeight < 1 {
+		return dotMapping{}, errors.New("Calibration is incomplete")
+	}
+
+	topLeft, bottomRight := calibration.Points[0], calibration.Points[2]
+
+	mapping := dotMapping{
+		left:   topLeft.DotX,
+		top:    topLeft.DotY,
+		width:  bottomRight.DotX - topLeft.DotX,
+		length: bottomRight.DotY - topLeft.DotY,
+		scaleX: float64(imageWidth) / float64(calibration.ImageWidth),
+		scaleY: float64(imageHeight) / float64(calibration.ImageHeight),
+	}
+
+	if mapping.width <= 0 || mapping.length <= 0 {
+		return dotMapping{}, errors.New("Calibration marks are out of order")
+	}
+
+	corners := make([]framePoint, 0, 4)
+	for _, point := range calibration.Points {
+		corners = append(corners, framePoint{X: point.PixelX * mapping.scaleX, Y: point.PixelY * mapping.scaleY})
+	}
+	mapping.quad = newSquareToQuad(corners)
+
+	return mapping, nil
+}
+
+func (mapping dotMapping) toPixels(x, y float64) (float64, float64) {
+	return mapping.quad.apply((x-mapping.left)/mapping.width, (y-mapping.top)/mapping.length)
+}
+
+func (mapping dotMapping) toDots(x, y float64) (float64, float64) {
+	s, t := mapping.quad.invert(x, y)
+
+	return mapping.left + s*mapping.width, mapping.top + t*mapping.length
+}
+
+// Where a ZPL format puts its fields, and what it says about the label
+type zplLayout struct {
+	origins  []fieldOrigin
+	width    int
+	length   int
+	inverted bool
+}
+
+func parseZPLLayout(source string) zplLayout {
+	var layout zplLayout
+	var homeX, homeY int
+
+	for _, label := range zpl.Parse(source).Labels {
+		fieldOrigins := make(map[*zpl.Command]*zpl.Field)
+		for _, field := range label.Fields {
+			if field.Origin != nil {
+				fieldOrigins[field.Origin] = field
+			}
+		}
+
+		for _, command := range label.Commands {
+			switch command.Name {
+			case "LH":
+				homeX, _ = command.Int(0, 0)
+				homeY, _ = command.Int(1, 0)
+			case "PW":
+				layout.width, _ = command.Int(0, layout.width)
+			case "LL":
+				layout.length, _ = command.Int(0, layout.length)
+			case "PO":
+				layout.inverted = strings.HasPrefix(strings.ToUpper(command.Arg(0)), "I")
+			case "FO", "FT":
+				// ^FT without a position carries on from the last field, which
+				// would take typesetting the field to work out
+				if command.Name == "FT" && command.Arg(0) == "" && command.Arg(1) == "" {
+					continue
+				}
+
+				x, _ := command.Int(0, 0)
+				y, _ := command.Int(1, 0)
+
+				origin := fieldOrigin{Command: command.Name, X: homeX + x, Y: homeY + y}
+				if field, ok := fieldOrigins[command]; ok {
+					origin.Data = field.Text
+				}
+
+				layout.origins = append(layout.origins, origin)
+			}
+		}
+	}
+
+	return layout
+}
+
+// Turn a picture of the calibration pattern into a calibration for the printer's camera
+func (worker *printerWorker) calibrate(db *bolt.DB, status *printJobStatus, picture []byte) error {
+	media, err := mediaProfileNamed(status.Media)
+
+	if err != nil {
+		return err
+	}
+
+	centers, imageWidth, imageHeight, err := findCalibrationMarks(picture)
+
+	if err != nil {
+		return err
+	}
+
+	width, length := media.dots(worker.config.DPI)
+	dots := [][2]int{
+		{calibrationMarkCenter, calibrationMarkCenter},
+		{width - calibrationMarkCenter, calibrationMarkCenter},
+		{width - calibrationMarkCenter, length - calibrationMarkCenter},
+		{calibrationMarkCenter, length - calibrationMarkCenter},
+	}
+
+	calibration := calibrationRecord{
+		Printer:     worker.name,
+		Camera:      worker.primaryCamera().name,
+		Jobid:       status.Jobid,
+		Media:       status.Media,
+		DPI:         media.dpi(worker.config.DPI),
+		ImageWidth:  imageWidth,
+		ImageHeight: imageHeight,
+		Created:     time.Now().Format(time.RFC3339),
+	}
+
+	for index, center := range centers {
+		calibration.Points = append(calibration.Points, calibrationPoint{
+			DotX:   float64(dots[index][0]),
+			DotY:   float64(dots[index][1]),
+			PixelX: center.X,
+			PixelY: center.Y,
+		})
+	}
+
+	if _, err := calibration.mapping(imageWidth, imageHeight); err != nil {
+		return err
+	}
+
+	status.Calibration = status.Jobid
+
+	return PutRecord(db, &calibration)
+}
+
+// Use the printer's calibration to find where the job's fields landed in its
+// picture and how big the label came out
+func (worker *printerWorker) measureLabel(db *bolt.DB, status *printJobStatus, picture []byte) error {
+	calibration := calibrationRecord{Printer: worker.name, Camera: worker.primaryCamera().name}
+
+	if GetRecord(db, &calibration) != nil {
+		return nil
+	}
+
+	decoded, err := imaging.Decode(bytes.NewBuffer(picture))
+
+	if err != nil {
+		return err
+	}
+
+	bounds := decoded.Bounds()
+	mapping, err := calibration.mapping(bounds.Dx(), bounds.Dy())
+
+	if err != nil {
+		return err
+	}
+
+	media, err := mediaProfileNamed(status.Media)
+
+	if err != nil {
+		return err
+	}
+
+	layout := parseZPLLayout(status.ZPL)
+	mediaWidth, mediaLength := media.dots(worker.config.DPI)
+
+	if layout.width <= 0 {
+		layout.width = mediaWidth
+	}
+
+	if layout.length <= 0 {
+		layout.length = mediaLength
+	}
+
+	status.Calibration = calibration.Jobid
+	status.ImageWidth = bounds.Dx()
+	status.ImageHeight = bounds.Dy()
+	status.FieldOrigins = layout.origins
+
+	for index := range status.FieldOrigins {
+		origin := &status.FieldOrigins[index]
+		x, y := float64(origin.X), float64(origin.Y)
+
+		// ^POI turns the whole label upside down
+		if layout.inverted {
+			x, y = float64(layout.width)-x, float64(layout.length)-y
+		}
+
+		origin.PixelX, origin.PixelY = mapping.toPixels(x, y)
+	}
+
+	// A cropped picture is all label, otherwise find the label in it
+	var corners []framePoint
+	if worker.primaryCamera().crop != nil {
+		corners = []framePoint{
+			{X: 0, Y: 0},
+			{X: float64(bounds.Dx()), Y: 0},
+			{X: float64(bounds.Dx()), Y: float64(bounds.Dy())},
+			{X: 0, Y: float64(bounds.Dy())},
+		}
+	} else if corners, err = findLabelCorners(decoded); err != nil {
+		return err
+	}
+
+	labelCorners := make([]framePoint, 0, 4)
+	for _, corner := range corners {
+		x, y := mapping.toDots(corner.X, corner.Y)
+		labelCorners = append(labelCorners, framePoint{X: x, Y: y})
+	}
+
+	dpi := float64(media.dpi(worker.config.DPI))
+	mm := func(dots float64) float64 {
+		return math.Round(dots/dpi*mmPerInch*10) / 10
+	}
+
+	status.Measurement = &labelMeasurement{
+		WidthMM:          mm((distance(labelCorners[0], labelCorners[1]) + distance(labelCorners[3], labelCorners[2])) / 2),
+		LengthMM:         mm((distance(labelCorners[0], labelCorners[3]) + distance(labelCorners[1], labelCorners[2])) / 2),
+		ExpectedWidthMM:  mm(float64(layout.width)),
+		ExpectedLengthMM: mm(float64(layout.length)),
+	}
+
+	return nil
+}
+
+// POST /printers/:name/calibrate prints the calibration pattern; the picture
+// of it becomes the printer's calibration
+func calibratePrinter(database *bolt.DB, workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		worker, ok := workers[c.Param("name")]
+
+		if !ok {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Printer not found"})
+		}
+
+		request := struct {
+			Author string `json:"author"`
+		}{}
+		c.Bind(&request)
+
+		mediaName := worker.mediaName("")
+		media, err := mediaProfileNamed(mediaName)
+
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+		}
+
+		width, length := media.dots(worker.config.DPI)
+
+		jobid, err := submitJob(database, worker, &printJobRequest{
+			ZPL:       calibrationPattern(width, length),
+			Printer:   worker.name,
+			Media:     mediaName,
+			Calibrate: true,
+			Author:    request.Author,
+		})
+
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+		}
+
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/job/%s", jobid))
+	}
+}
@@ -0,0 +1,360 @@
+package zplorama
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	raspistillCamera = "raspistill"
+	libcameraCamera  = "libcamera"
+	v4l2Camera       = "v4l2"
+	ffmpegCamera     = "ffmpeg"
+	httpCamera       = "http"
+	directoryCamera  = "directory"
+	fakeCamera       = "fake"
+	renderCamera     = "render"
+)
+
+const defaultCameraName = "default"
+
+// Grabs one frame from a V4L2 device; {{.Device}}, {{.Width}} and {{.Height}} are filled in
+var defaultFFmpegCommand = []string{
+	"ffmpeg", "-loglevel", "error",
+	"-f", "v4l2", "-video_size", "{{.Width}}x{{.Height}}", "-i", "{{.Device}}",
+	"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-",
+}
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+// Camera photographs whatever the printer just printed, returning PNG bytes
+type Camera interface {
+	Capture() ([]byte, error)
+}
+
+func cameraConfigNamed(name string) (cameraConfig, error) {
+	if name == "" {
+		name = defaultCameraName
+	}
+
+	if config, ok := Config.Cameras[name]; ok {
+		return config, nil
+	} else if name == defaultCameraName {
+		return cameraConfig{Kind: raspistillCamera}, nil
+	}
+
+	return cameraConfig{}, fmt.Errorf("Unknown camera %v", name)
+}
+
+func newCamera(config cameraConfig) (Camera, error) {
+	timeout := parseDurationOr(config.Timeout, 30*time.Second)
+	warmup := parseDurationOr(config.Warmup, 3*time.Second)
+
+	if config.Persistent {
+		return newWarmCamera(config, warmup, timeout)
+	}
+
+	switch config.Kind {
+	case raspistillCamera, "":
+		args := append([]string{"-t", fmt.Sprint(warmup.Milliseconds()), "-e", "png", "-o", "-"}, raspistillArgs(config)...)
+
+		return &commandCamera{command: "raspistill", args: args, timeout: timeout}, nil
+	case libcameraCamera:
+		args := append([]string{"-n", "-t", fmt.Sprint(warmup.Milliseconds()), "-e", "png", "-o", "-"}, libcameraArgs(config)...)
+
+		return &commandCamera{command: "libcamera-still", args: args, timeout: timeout}, nil
+	case v4l2Camera:
+		// Skip the first few frames while exposure settles
+		frames := int(warmup.Seconds() * 10)
+
+		args := append(v4l2Args(config), fmt.Sprintf("--stream-skip=%v", frames), "--stream-count=1")
+
+		return &commandCamera{command: "v4l2-ctl", args: args, timeout: timeout}, nil
+	case ffmpegCamera:
+		command, err := expandCommandTemplate(config.Command, defaultFFmpegCommand, config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &commandCamera{command: command[0], args: command[1:], timeout: timeout}, nil
+	case httpCamera:
+		if config.URL == "" {
+			return nil, errors.New("HTTP camera needs a url")
+		}
+
+		return &httpSnapshotCamera{url: config.URL, client: &http.Client{Timeout: timeout}}, nil
+	case directoryCamera:
+		if config.Directory == "" {
+			return nil, errors.New("Directory camera needs a directory")
+		}
+
+		return &directoryWatchCamera{directory: config.Directory, timeout: timeout}, nil
+	case fakeCamera:
+		return &fixedImageCamera{file: config.File}, nil
+	case renderCamera:
+		// Hooked up to its printer's render transport once the printer is set up
+		return &renderedLabelCamera{}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown camera kind %v", config.Kind)
+}
+
+func raspistillArgs(config cameraConfig) []string {
+	args := sizeArgs(config, "-w", "-h")
+
+	if config.Device != "" {
+		args = append(args, "-cs", config.Device)
+	}
+
+	return args
+}
+
+func libcameraArgs(config cameraConfig) []string {
+	args := sizeArgs(config, "--width", "--height")
+
+	if config.Device != "" {
+		args = append(args, "--camera", config.Device)
+	}
+
+	return args
+}
+
+// Stream MJPEG frames from the device to stdout
+func v4l2Args(config cameraConfig) []string {
+	device := config.Device
+	if device == "" {
+		device = "/dev/video0"
+	}
+
+	format := "pixelformat=MJPG"
+	if config.Width > 0 && config.Height > 0 {
+		format = fmt.Sprintf("width=%v,height=%v,%v", config.Width, config.Height, format)
+	}
+
+	return []string{
+		"--device", device,
+		"--set-fmt-video=" + format,
+		"--stream-mmap",
+		"--stream-to=-",
+	}
+}
+
+func sizeArgs(config cameraConfig, widthFlag, heightFlag string) []string {
+	if config.Width > 0 && config.Height > 0 {
+		return []string{widthFlag, strconv.Itoa(config.Width), heightFlag, strconv.Itoa(config.Height)}
+	}
+
+	return []string{}
+}
+
+func expandCommandTemplate(commandTemplate []string, defaultTemplate []string, config cameraConfig) ([]string, error) {
+	if len(commandTemplate) == 0 {
+		commandTemplate = defaultTemplate
+	}
+
+	values := cameraConfig{Device: "/dev/video0", Width: 1280, Height: 720}
+	if config.Device != "" {
+		values.Device = config.Device
+	}
+	if config.Width > 0 && config.Height > 0 {
+		values.Width, values.Height = config.Width, config.Height
+	}
+
+	command := make([]string, 0, len(commandTemplate))
+
+	for _, arg := range commandTemplate {
+		argTemplate, err := template.New("arg").Parse(arg)
+
+		if err != nil {
+			return nil, fmt.Errorf("Bad camera command argument %q: %v", arg, err)
+		}
+
+		var expanded bytes.Buffer
+		err = argTemplate.Execute(&expanded, values)
+
+		if err != nil {
+			return nil, fmt.Errorf("Bad camera command argument %q: %v", arg, err)
+		}
+
+		command = append(command, expanded.String())
+	}
+
+	return command, nil
+}
+
+// Cameras all hand back whatever format they like; jobs store PNGs
+func normalizeImage(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, pngMagic) {
+		return data, nil
+	}
+
+	picture, err := imaging.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, fmt.Errorf("Camera returned an unreadable image: %v", err)
+	}
+
+	var pngBytes bytes.Buffer
+	err = imaging.Encode(&pngBytes, picture, imaging.PNG)
+
+	return pngBytes.Bytes(), err
+}
+
+// Runs a program that writes a picture to stdout
+type commandCamera struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func (camera *commandCamera) Capture() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), camera.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, camera.command, camera.args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%v: timed out after %v", camera.command, camera.timeout)
+	} else if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%v: %v (%v)", camera.command, err, message)
+		}
+
+		return nil, fmt.Errorf("%v: %v", camera.command, err)
+	}
+
+	return normalizeImage(out)
+}
+
+// Fetches a still from a URL, like most IP cameras offer
+type httpSnapshotCamera struct {
+	url    string
+	client *http.Client
+}
+
+func (camera *httpSnapshotCamera) Capture() ([]byte, error) {
+	response, err := camera.client.Get(camera.url)
+
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Snapshot URL returned %v", response.Status)
+	}
+
+	data, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeImage(data)
+}
+
+// Waits for something else (a tethering tool, a phone app) to drop a new picture into a directory
+type directoryWatchCamera struct {
+	directory string
+	timeout   time.Duration
+}
+
+func newestFileSince(directory string, since time.Time) (string, os.FileInfo) {
+	entries, err := os.ReadDir(directory)
+
+	if err != nil {
+		return "", nil
+	}
+
+	var newestPath string
+	var newestInfo os.FileInfo
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since) {
+			continue
+		}
+
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+			newestPath = filepath.Join(directory, entry.Name())
+			newestInfo = info
+		}
+	}
+
+	return newestPath, newestInfo
+}
+
+func (camera *directoryWatchCamera) Capture() ([]byte, error) {
+	return waitForNewFile(camera.directory, time.Now(), camera.timeout)
+}
+
+func waitForNewFile(directory string, since time.Time, timeout time.Duration) ([]byte, error) {
+	giveUp := time.Now().Add(timeout)
+	lastSize := int64(-1)
+
+	for time.Now().Before(giveUp) {
+		path, info := newestFileSince(directory, since)
+
+		// Only read it once it has stopped growing
+		if info != nil && info.Size() > 0 && info.Size() == lastSize {
+			data, err := os.ReadFile(path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return normalizeImage(data)
+		} else if info != nil {
+			lastSize = info.Size()
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	return nil, fmt.Errorf("No new picture showed up in %v within %v", directory, timeout)
+}
+
+// Always returns the same picture; for development and testing
+type fixedImageCamera struct {
+	file string
+}
+
+func (camera *fixedImageCamera) Capture() ([]byte, error) {
+	if camera.file != "" {
+		data, err := os.ReadFile(camera.file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return normalizeImage(data)
+	}
+
+	picture := imaging.New(640, 480, color.Gray{Y: 200})
+
+	var pngBytes bytes.Buffer
+	err := imaging.Encode(&pngBytes, picture, imaging.PNG)
+
+	return pngBytes.Bytes(), err
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package zplorama
+
+import (
+	"os"
+	"syscall"
+)
+
+// raspistill -s and libcamera-still --signal take a picture on SIGUSR1
+func signalCapture(process *os.Process) error {
+	return process.Signal(syscall.SIGUSR1)
+}
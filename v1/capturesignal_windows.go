@@ -0,0 +1,10 @@
+package zplorama
+
+import (
+	"errors"
+	"os"
+)
+
+func signalCapture(process *os.Process) error {
+	return errors.New("Signal-mode cameras aren't supported on Windows")
+}
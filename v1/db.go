@@ -16,7 +16,7 @@ func createDB(filename string) *bolt.DB {
 
 	// Make default tables
 	db.Update(func(tx *bolt.Tx) error {
-		buckets := []string{printjobTable, jobTimeTable}
+		buckets := []string{printjobTable, jobTimeTable, queueTable, baselineTable, framesTable, calibrationTable}
 
 		for _, bucket := range buckets {
 			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
@@ -77,6 +77,15 @@ func PutRecord(database *bolt.DB, record Boltable) error {
 	return err
 }
 
+// DeleteRecord removes a boltable from the database
+func DeleteRecord(database *bolt.DB, record Boltable) error {
+	return database.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(record.Table()))
+
+		return bucket.Delete([]byte(record.Key()))
+	})
+}
+
 // Boltable represents a struct that can be JSON serialized to BoltDB
 type Boltable interface {
 	Table() string
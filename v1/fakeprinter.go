@@ -0,0 +1,482 @@
+package zplorama
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Canned SGD answers; setvar adds to (or overrides) these
+var fakePrinterVars = map[string]string{
+	"device.product_name":    "ZT410",
+	"device.friendly_name":   "FAKEPRINTER",
+	"device.unique_id":       "FAKE00000001",
+	"appl.name":              "V75.20.01Z",
+	"head.resolution.in_dpi": "203",
+	"media.printmode":        "T",
+}
+
+const fakePrinterIdentification = "ZT410-203dpi,V75.20.01Z,8,8192KB,C"
+
+type fakePrinterProblems struct {
+	PaperOut  bool `json:"paper_out"`
+	HeadOpen  bool `json:"head_open"`
+	Paused    bool `json:"paused"`
+	RibbonOut bool `json:"ribbon_out"`
+}
+
+type fakePrinterState struct {
+	fakePrinterProblems
+	FormatsInBuffer int `json:"formats_in_buffer"`
+	LabelsRemaining int `json:"labels_remaining"`
+	LabelsPrinted   int `json:"labels_printed"`
+}
+
+type fakeFormat struct {
+	zpl      string
+	quantity int
+}
+
+type fakePrinter struct {
+	lock        sync.Mutex
+	problems    fakePrinterProblems
+	formats     []fakeFormat
+	remaining   int
+	printed     int
+	labelLength int
+	vars        map[string]string
+	labelDir    string
+	labelTime   time.Duration
+	wake        chan struct{}
+}
+
+// One connection's worth of parser state; ZPL can arrive split across reads
+type fakeSession struct {
+	pending  []byte
+	caret    byte
+	tilde    byte
+	label    *bytes.Buffer
+	quantity int
+}
+
+func (printer *fakePrinter) ready() bool {
+	problems := printer.problems
+	return !(problems.PaperOut || problems.HeadOpen || problems.Paused || problems.RibbonOut)
+}
+
+func (printer *fakePrinter) state() fakePrinterState {
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+
+	return fakePrinterState{
+		fakePrinterProblems: printer.problems,
+		FormatsInBuffer:     len(printer.formats),
+		LabelsRemaining:     printer.remaining,
+		LabelsPrinted:       printer.printed,
+	}
+}
+
+func (printer *fakePrinter) poke() {
+	select {
+	case printer.wake <- struct{}{}:
+	default:
+	}
+}
+
+func flagDigit(flag bool) int {
+	if flag {
+		return 1
+	}
+
+	return 0
+}
+
+func (printer *fakePrinter) hostStatus() string {
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+
+	problems := printer.problems
+
+	return fmt.Sprintf(
+		"\x02030,%v,%v,%04d,%03d,0,0,0,000,0,0,0\x03\r\n"+
+			"\x02001,0,%v,%v,0,0,0,0,%08d,1,000\x03\r\n"+
+			"\x021234,0\x03\r\n",
+		flagDigit(problems.PaperOut),
+		flagDigit(problems.Paused),
+		printer.labelLength,
+		len(printer.formats),
+		flagDigit(problems.HeadOpen),
+		flagDigit(problems.RibbonOut),
+		printer.remaining,
+	)
+}
+
+func (printer *fakePrinter) handleSGD(conn net.Conn, line string) {
+	fields := strings.Fields(line)
+
+	if len(fields) < 4 {
+		return
+	}
+
+	unquote := func(value string) string {
+		return strings.Trim(value, "\"")
+	}
+
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+
+	switch strings.ToLower(fields[2]) {
+	case "getvar":
+		value, ok := printer.vars[unquote(fields[3])]
+		if !ok {
+			value = "?"
+		}
+		fmt.Fprintf(conn, "\"%v\"", value)
+	case "setvar":
+		if len(fields) > 4 {
+			printer.vars[unquote(fields[3])] = unquote(strings.Join(fields[4:], " "))
+		}
+	}
+}
+
+// Commands whose parameters we need to see before acting on them
+var fakePrinterParamCommands = map[string]bool{
+	"PQ": true,
+	"LL": true,
+}
+
+func (printer *fakePrinter) handleImmediate(conn net.Conn, session *fakeSession, name string) {
+	switch name {
+	case "HS":
+		conn.Write([]byte(printer.hostStatus()))
+	case "HI":
+		fmt.Fprintf(conn, "\x02%v\x03\r\n", fakePrinterIdentification)
+	case "JA":
+		printer.lock.Lock()
+		printer.formats = nil
+		printer.remaining = 0
+		printer.lock.Unlock()
+		session.label = nil
+		log.Printf("Cancelled all formats")
+	case "PP":
+		printer.lock.Lock()
+		printer.problems.Paused = true
+		printer.lock.Unlock()
+	case "PS":
+		printer.lock.Lock()
+		printer.problems.Paused = false
+		printer.lock.Unlock()
+		printer.poke()
+	}
+}
+
+func (printer *fakePrinter) endFormat(session *fakeSession) {
+	zpl := session.label.String()
+	session.label = nil
+
+	// Formats that only set things up don't put anything on a label
+	if !strings.Contains(zpl, string(session.caret)+"FS") {
+		return
+	}
+
+	printer.lock.Lock()
+	printer.formats = append(printer.formats, fakeFormat{zpl: zpl, quantity: session.quantity})
+	printer.lock.Unlock()
+
+	printer.poke()
+}
+
+func (printer *fakePrinter) consume(conn net.Conn, session *fakeSession, data []byte) {
+	session.pending = append(session.pending, data...)
+
+	for len(session.pending) > 0 {
+		pending := session.pending
+		commandAt := bytes.IndexAny(pending, string([]byte{session.caret, session.tilde}))
+
+		// SGD commands live outside of formats, one per line
+		if sgdAt := bytes.Index(pending, []byte("! U1")); session.label == nil && sgdAt != -1 && (commandAt == -1 || sgdAt < commandAt) {
+			lineEnd := bytes.IndexByte(pending[sgdAt:], '\n')
+			if lineEnd == -1 {
+				return
+			}
+
+			printer.handleSGD(conn, string(pending[sgdAt:sgdAt+lineEnd]))
+			session.pending = pending[sgdAt+lineEnd+1:]
+			continue
+		}
+
+		if commandAt == -1 {
+			if session.label != nil {
+				session.label.Write(pending)
+			}
+			session.pending = nil
+			return
+		} else if commandAt > 0 {
+			if session.label != nil {
+				session.label.Write(pending[:commandAt])
+			}
+			session.pending = pending[commandAt:]
+			continue
+		}
+
+		if len(pending) < 3 {
+			return
+		}
+
+		prefix := pending[0]
+		name := strings.ToUpper(string(pending[1:3]))
+
+		// Prefix changes take a single character parameter
+		if name == "CC" || name == "CT" {
+			if len(pending) < 4 {
+				return
+			}
+
+			if name == "CC" {
+				session.caret = pending[3]
+			} else {
+				session.tilde = pending[3]
+			}
+
+			session.pending = pending[4:]
+			continue
+		}
+
+		consumed := 3
+		var params string
+
+		if fakePrinterParamCommands[name] {
+			paramsEnd := bytes.IndexAny(pending[3:], string([]byte{session.caret, session.tilde}))
+			if paramsEnd == -1 {
+				return
+			}
+
+			params = string(pending[3 : 3+paramsEnd])
+			consumed += paramsEnd
+		}
+
+		if session.label != nil {
+			session.label.Write(pending[:consumed])
+		}
+		session.pending = pending[consumed:]
+
+		if prefix == session.tilde {
+			printer.handleImmediate(conn, session, name)
+			continue
+		}
+
+		switch name {
+		case "XA":
+			session.label = new(bytes.Buffer)
+			session.label.Write(pending[:consumed])
+			session.quantity = 1
+		case "XZ":
+			if session.label != nil {
+				printer.endFormat(session)
+			}
+		case "PQ":
+			quantity, err := strconv.Atoi(strings.TrimSpace(strings.Split(params, ",")[0]))
+			if err == nil && quantity > 0 {
+				session.quantity = quantity
+			}
+		case "LL":
+			length, err := strconv.Atoi(strings.TrimSpace(strings.Split(params, ",")[0]))
+			if err == nil && length > 0 {
+				printer.lock.Lock()
+				printer.labelLength = length
+				printer.lock.Unlock()
+			}
+		}
+	}
+}
+
+func (printer *fakePrinter) serveConnection(conn net.Conn) {
+	defer conn.Close()
+
+	session := &fakeSession{caret: '^', tilde: '~'}
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := conn.Read(buf)
+
+		if n > 0 {
+			printer.consume(conn, session, buf[:n])
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (printer *fakePrinter) recordLabel(zpl string) {
+	if printer.labelDir == "" {
+		return
+	}
+
+	filename := filepath.Join(printer.labelDir, fmt.Sprintf("%v-%06d.zpl", time.Now().Format("20060102-150405"), printer.printed))
+
+	err := os.WriteFile(filename, []byte(zpl), 0644)
+
+	if err != nil {
+		log.Printf("Could not record label: %v", err)
+	}
+}
+
+// Work through the format buffer one label at a time, stalling while the printer has a problem
+func (printer *fakePrinter) printLabels() {
+	for {
+		printer.lock.Lock()
+
+		if len(printer.formats) == 0 || !printer.ready() {
+			printer.lock.Unlock()
+			<-printer.wake
+			continue
+		}
+
+		format := printer.formats[0]
+		if printer.remaining == 0 {
+			printer.remaining = format.quantity
+		}
+
+		printer.lock.Unlock()
+
+		time.Sleep(printer.labelTime)
+
+		printer.lock.Lock()
+
+		// Cancelled or stopped while the label was printing
+		if len(printer.formats) == 0 || !printer.ready() {
+			printer.lock.Unlock()
+			continue
+		}
+
+		printer.remaining--
+		printer.printed++
+		printer.recordLabel(format.zpl)
+		log.Printf("Printed label %v (%v left in batch)", printer.printed, printer.remaining)
+
+		if printer.remaining == 0 {
+			printer.formats = printer.formats[1:]
+		}
+
+		printer.lock.Unlock()
+	}
+}
+
+func (printer *fakePrinter) getState(c echo.Context) error {
+	return c.JSON(http.StatusOK, printer.state())
+}
+
+func (printer *fakePrinter) setState(c echo.Context) error {
+	var update struct {
+		PaperOut  *bool `json:"paper_out"`
+		HeadOpen  *bool `json:"head_open"`
+		Paused    *bool `json:"paused"`
+		RibbonOut *bool `json:"ribbon_out"`
+	}
+
+	err := c.Bind(&update)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+	}
+
+	printer.lock.Lock()
+	if update.PaperOut != nil {
+		printer.problems.PaperOut = *update.PaperOut
+	}
+	if update.HeadOpen != nil {
+		printer.problems.HeadOpen = *update.HeadOpen
+	}
+	if update.Paused != nil {
+		printer.problems.Paused = *update.Paused
+	}
+	if update.RibbonOut != nil {
+		printer.problems.RibbonOut = *update.RibbonOut
+	}
+	printer.lock.Unlock()
+
+	printer.poke()
+
+	return c.JSON(http.StatusOK, printer.state())
+}
+
+func newFakePrinter(labelDir string, labelTime time.Duration, paperOut bool, headOpen bool) *fakePrinter {
+	vars := make(map[string]string)
+	for name, value := range fakePrinterVars {
+		vars[name] = value
+	}
+
+	return &fakePrinter{
+		problems:    fakePrinterProblems{PaperOut: paperOut, HeadOpen: headOpen},
+		labelLength: 1218,
+		vars:        vars,
+		labelDir:    labelDir,
+		labelTime:   labelTime,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Take connections until the listener gets closed
+func (printer *fakePrinter) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Printf("Accept: %v", err)
+			continue
+		}
+
+		go printer.serveConnection(conn)
+	}
+}
+
+// RunFakePrinter pretends to be a Zebra printer listening on listenAddress,
+// with a little HTTP API on controlPort for simulating paper out, head open etc.
+func RunFakePrinter(listenAddress string, controlPort int, labelDir string, labelTime time.Duration, paperOut bool, headOpen bool) {
+	printer := newFakePrinter(labelDir, labelTime, paperOut, headOpen)
+
+	if labelDir != "" {
+		err := os.MkdirAll(labelDir, 0755)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", listenAddress)
+
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+
+	go printer.printLabels()
+
+	e := echo.New()
+	e.HideBanner = true
+
+	e.GET("/state", printer.getState)
+	e.POST("/state", printer.setState)
+	go func() {
+		e.Logger.Fatal(e.Start(fmt.Sprintf(":%v", controlPort)))
+	}()
+
+	log.Printf("Fake printer listening on %v", listenAddress)
+
+	printer.serve(listener)
+}
@@ -36,6 +36,8 @@ var templates *template.Template
 func init() {
 	fm := make(template.FuncMap)
 	fm["GoogleSite"] = func() string { return Config.GoogleSite }
+	fm["Srcset"] = jobSrcset
+	fm["Percent"] = func(fraction float64) string { return fmt.Sprintf("%.2f%%", fraction*100) }
 
 	var err error
 	templates, err = template.New("webapp").Funcs(fm).ParseFS(templatesFS, "template/*.tpl")
@@ -180,7 +182,20 @@ func homePage(c echo.Context) error {
 		userName = c.Get("user_name").(string)
 		email = c.Get("email").(string)
 		picture = c.Get("picture").(string)
-		body = renderTemplateString("input-zpl-form", nil)
+		printers, _ := fetchPrintersCall()
+		media, _ := fetchMediaCall()
+		baselines, _ := fetchBaselinesCall()
+		body = renderTemplateString("input-zpl-form", struct {
+			Printers  []printerListing
+			Media     []mediaListing
+			Baselines []baselineRecord
+			Baseline  string
+		}{
+			Printers:  printers,
+			Media:     media,
+			Baselines: baselines,
+			Baseline:  c.QueryParam("baseline"),
+		})
 	} else {
 		body = renderTemplateString("please-log-in", nil)
 	}
@@ -210,6 +225,20 @@ func printMedia(c echo.Context) error {
 
 	printRequest.Author = c.Get("login").(*mail.Address).Address
 
+	lint, err := fetchLintCall(printRequest)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+	}
+
+	reject, err := lintRejects(lint.Diagnostics)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+	} else if reject {
+		return c.JSON(http.StatusBadRequest, lintRejection{Errmsg: "Not printing ZPL with problems", Diagnostics: lint.Diagnostics})
+	}
+
 	printHost := fmt.Sprintf("http://%v:%v/print", Config.PrintserviceHost, Config.PrintservicePort)
 
 	body, _ := json5.Marshal(&printRequest)
@@ -241,6 +270,48 @@ func printMedia(c echo.Context) error {
 	return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: "No idea what happened here."})
 }
 
+func fetchLintCall(printRequest *printJobRequest) (lintResponse, error) {
+	lintURL := fmt.Sprintf("http://%v:%v/lint", Config.PrintserviceHost, Config.PrintservicePort)
+
+	body, _ := json5.Marshal(printRequest)
+	response, err := http.Post(lintURL, "application/json", bytes.NewBuffer(body))
+
+	if err != nil {
+		return lintResponse{}, err
+	}
+
+	dec := json5.NewDecoder(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+		dec.Decode(&errMsg)
+
+		return lintResponse{}, errors.New(errMsg.Errmsg)
+	}
+
+	var lint lintResponse
+	err = dec.Decode(&lint)
+
+	return lint, err
+}
+
+func lintZPLView(c echo.Context) error {
+	if !(c.Get("logged_in").(bool)) {
+		return c.JSON(http.StatusUnauthorized, errJSON{Errmsg: "You're not logged in."})
+	}
+
+	lintRequest := new(printJobRequest)
+	c.Bind(lintRequest)
+
+	lint, err := fetchLintCall(lintRequest)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, lint)
+}
+
 func fetchJobCall(jobID string) (printJobStatus, error) {
 	jobURL := fmt.Sprintf("http://%v:%v/job/%v", Config.PrintserviceHost, Config.PrintservicePort, jobID)
 
@@ -270,6 +341,389 @@ func fetchJobCall(jobID string) (printJobStatus, error) {
 	return printJobStatus{}, errors.New("Unknown failure")
 }
 
+func fetchPrintersCall() ([]printerListing, error) {
+	printersURL := fmt.Sprintf("http://%v:%v/printers", Config.PrintserviceHost, Config.PrintservicePort)
+
+	response, err := http.Get(printersURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Print service returned %v listing printers", response.Status)
+	}
+
+	var printers []printerListing
+
+	dec := json5.NewDecoder(response.Body)
+	err = dec.Decode(&printers)
+
+	return printers, err
+}
+
+func fetchMediaCall() ([]mediaListing, error) {
+	mediaURL := fmt.Sprintf("http://%v:%v/media", Config.PrintserviceHost, Config.PrintservicePort)
+
+	response, err := http.Get(mediaURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Print service returned %v listing media", response.Status)
+	}
+
+	var media []mediaListing
+
+	dec := json5.NewDecoder(response.Body)
+	err = dec.Decode(&media)
+
+	return media, err
+}
+
+func fetchBaselinesCall() ([]baselineRecord, error) {
+	baselinesURL := fmt.Sprintf("http://%v:%v/baselines", Config.PrintserviceHost, Config.PrintservicePort)
+
+	response, err := http.Get(baselinesURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Print service returned %v listing baselines", response.Status)
+	}
+
+	var baselines []baselineRecord
+
+	dec := json5.NewDecoder(response.Body)
+	err = dec.Decode(&baselines)
+
+	return baselines, err
+}
+
+// Mark (POST) or unmark (DELETE) a job as a baseline
+func baselineJobCall(method string, jobID string, name string) (printJobStatus, error) {
+	jobURL := fmt.Sprintf("http://%v:%v/job/%v/baseline", Config.PrintserviceHost, Config.PrintservicePort, url.PathEscape(jobID))
+
+	body, _ := json5.Marshal(struct {
+		Name string `json:"name"`
+	}{Name: name})
+
+	request, err := http.NewRequest(method, jobURL, bytes.NewBuffer(body))
+
+	if err != nil {
+		return printJobStatus{}, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		return printJobStatus{}, err
+	}
+
+	dec := json5.NewDecoder(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+		dec.Decode(&errMsg)
+
+		return printJobStatus{}, errors.New(errMsg.Errmsg)
+	}
+
+	var status printJobStatus
+	err = dec.Decode(&status)
+
+	return status, err
+}
+
+func cancelJobCall(jobID string) (printJobStatus, error) {
+	jobURL := fmt.Sprintf("http://%v:%v/job/%v", Config.PrintserviceHost, Config.PrintservicePort, url.PathEscape(jobID))
+
+	request, err := http.NewRequest(http.MethodDelete, jobURL, nil)
+
+	if err != nil {
+		return printJobStatus{}, err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		return printJobStatus{}, err
+	}
+
+	dec := json5.NewDecoder(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+		dec.Decode(&errMsg)
+
+		return printJobStatus{}, errors.New(errMsg.Errmsg)
+	}
+
+	var status printJobStatus
+	err = dec.Decode(&status)
+
+	return status, err
+}
+
+func stopJob(c echo.Context) error {
+	if !(c.Get("logged_in").(bool)) {
+		return c.JSON(http.StatusUnauthorized, errJSON{Errmsg: "You're not logged in."})
+	}
+
+	job, err := cancelJobCall(c.Param("id"))
+
+	if err != nil {
+		return c.JSON(http.StatusExpectationFailed, errJSON{Errmsg: err.Error()})
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		hotwireResponse{
+			Message: string(job.Status),
+			DivID:   "jobstatus",
+			HTML:    renderTemplateString("job-status-part", job),
+		})
+}
+
+func setBaseline(c echo.Context) error {
+	if !(c.Get("logged_in").(bool)) {
+		return c.JSON(http.StatusUnauthorized, errJSON{Errmsg: "You're not logged in."})
+	}
+
+	request := struct {
+		Name string `json:"name" form:"name" query:"name"`
+	}{}
+	c.Bind(&request)
+
+	job, err := baselineJobCall(c.Request().Method, c.Param("id"), request.Name)
+
+	if err != nil {
+		return c.JSON(http.StatusExpectationFailed, errJSON{Errmsg: err.Error()})
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		hotwireResponse{
+			Message: string(job.Status),
+			DivID:   "jobstatus",
+			HTML:    renderTemplateString("job-status-part", job),
+		})
+}
+
+// Pass a picture the print service makes straight through
+func proxyJobImage(c echo.Context, path string) error {
+	imageURL := fmt.Sprintf(
+		"http://%v:%v/job/%v/%v",
+		Config.PrintserviceHost,
+		Config.PrintservicePort,
+		url.PathEscape(c.Param("id")),
+		path)
+
+	response, err := http.Get(imageURL)
+
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, errJSON{Errmsg: err.Error()})
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+
+		dec := json5.NewDecoder(response.Body)
+		dec.Decode(&errMsg)
+
+		return c.JSON(response.StatusCode, errMsg)
+	}
+
+	c.Response().Header().Set("Cache-Control", "max-age=31536000")
+
+	return c.Stream(http.StatusOK, response.Header.Get("Content-Type"), response.Body)
+}
+
+func displayJobDiff(c echo.Context) error {
+	return proxyJobImage(c, "diff/"+url.PathEscape(c.Param("baseline")))
+}
+
+func displayJobTimelapse(c echo.Context) error {
+	return proxyJobImage(c, "timelapse.gif")
+}
+
+func displayJobFrame(c echo.Context) error {
+	return proxyJobImage(c, "frames/"+url.PathEscape(c.Param("frame")))
+}
+
+func displayJobRendering(c echo.Context) error {
+	return proxyJobImage(c, "rendered.png")
+}
+
+func renderZPLView(c echo.Context) error {
+	if !(c.Get("logged_in").(bool)) {
+		return c.JSON(http.StatusUnauthorized, errJSON{Errmsg: "You're not logged in."})
+	}
+
+	renderRequest := new(printJobRequest)
+	c.Bind(renderRequest)
+
+	renderURL := fmt.Sprintf("http://%v:%v/render", Config.PrintserviceHost, Config.PrintservicePort)
+
+	body, _ := json5.Marshal(renderRequest)
+	response, err := http.Post(renderURL, "application/json", bytes.NewBuffer(body))
+
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, errJSON{Errmsg: err.Error()})
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+
+		dec := json5.NewDecoder(response.Body)
+		dec.Decode(&errMsg)
+
+		return c.JSON(response.StatusCode, errMsg)
+	}
+
+	return c.Stream(http.StatusOK, response.Header.Get("Content-Type"), response.Body)
+}
+
+func displayLiveView(c echo.Context) error {
+	var userName, email, picture, body string
+
+	if c.Get("logged_in").(bool) == true {
+		userName = c.Get("user_name").(string)
+		email = c.Get("email").(string)
+		picture = c.Get("picture").(string)
+
+		printers, err := fetchPrintersCall()
+
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, errJSON{Errmsg: err.Error()})
+		}
+
+		var printer *printerListing
+		for index := range printers {
+			if printers[index].Name == c.Param("name") {
+				printer = &printers[index]
+			}
+		}
+
+		if printer == nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Printer not found"})
+		}
+
+		body = renderTemplateString("printer-live", printer)
+	} else {
+		body = renderTemplateString("please-log-in", nil)
+	}
+
+	return c.Render(http.StatusOK, "main", struct {
+		Title   string
+		User    string
+		Email   string
+		Picture string
+		Body    string
+	}{
+		Title:   fmt.Sprintf("ZPL-O-Rama: %v live", c.Param("name")),
+		User:    userName,
+		Email:   email,
+		Picture: picture,
+		Body:    body,
+	})
+}
+
+// Print the calibration pattern on a printer and go watch the job
+func calibratePrinterView(c echo.Context) error {
+	if !(c.Get("logged_in").(bool)) {
+		return c.JSON(http.StatusUnauthorized, errJSON{Errmsg: "You're not logged in."})
+	}
+
+	calibrateURL := fmt.Sprintf("http://%v:%v/printers/%v/calibrate", Config.PrintserviceHost, Config.PrintservicePort, url.PathEscape(c.Param("name")))
+
+	body, _ := json5.Marshal(struct {
+		Author string `json:"author"`
+	}{Author: c.Get("login").(*mail.Address).Address})
+
+	response, err := http.Post(calibrateURL, "application/json", bytes.NewBuffer(body))
+
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, errJSON{Errmsg: err.Error()})
+	}
+	defer response.Body.Close()
+
+	dec := json5.NewDecoder(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+		dec.Decode(&errMsg)
+
+		return c.JSON(http.StatusBadRequest, errMsg)
+	}
+
+	var status printJobStatus
+	dec.Decode(&status)
+
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/job/%v", status.Jobid))
+}
+
+// Relay the print service's MJPEG stream for one of the printer's cameras, a chunk at a time as it arrives
+func streamLiveView(c echo.Context) error {
+	if !(c.Get("logged_in").(bool)) {
+		return c.JSON(http.StatusUnauthorized, errJSON{Errmsg: "You're not logged in."})
+	}
+
+	liveURL := fmt.Sprintf("http://%v:%v/printers/%v/live?camera=%v", Config.PrintserviceHost, Config.PrintservicePort, url.PathEscape(c.Param("name")), url.QueryEscape(c.QueryParam("camera")))
+
+	// Hang up on the print service when the viewer goes away
+	request, err := http.NewRequestWithContext(c.Request().Context(), http.MethodGet, liveURL, nil)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+	}
+
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, errJSON{Errmsg: err.Error()})
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var errMsg errJSON
+
+		dec := json5.NewDecoder(response.Body)
+		dec.Decode(&errMsg)
+
+		return c.JSON(response.StatusCode, errMsg)
+	}
+
+	c.Response().Header().Set("Content-Type", response.Header.Get("Content-Type"))
+	c.Response().Header().Set("Cache-Control", "no-cache, no-store")
+	c.Response().WriteHeader(http.StatusOK)
+
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := response.Body.Read(buf)
+
+		if n > 0 {
+			if _, writeErr := c.Response().Write(buf[:n]); writeErr != nil {
+				return nil
+			}
+
+			c.Response().Flush()
+		}
+
+		if err != nil {
+			return nil
+		}
+	}
+}
+
 func displayJob(c echo.Context) error {
 	job, err := fetchJobCall(c.Param("id"))
 
@@ -315,8 +769,10 @@ func displayJobJSON(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
 	}
 
-	if job.ImageB64Small == "" {
-		job.ImageB64Small, err = shrinkImage(job.ImageB64)
+	for index := range job.Images {
+		if err == nil && job.Images[index].ImageB64Small == "" {
+			job.Images[index].ImageB64Small, err = shrinkImage(job.Images[index].ImageB64)
+		}
 	}
 
 	if err != nil {
@@ -332,38 +788,67 @@ func displayJobJSON(c echo.Context) error {
 	return c.JSON(http.StatusOK, job)
 }
 
+// GET /job/:id/image.png is the preview of the first camera's picture,
+// /job/:id/image/:camera.png of any other's, with ?w= to pick a width
 func displaySmallJobImage(c echo.Context) error {
 	job, err := fetchJobCall(c.Param("id"))
 
-	if job.Done {
-		c.Response().Header().Set("Cache-Control", "max-age=31536000")
-	} else {
-		c.Response().Header().Set("Cache-Control", "max-age=10")
-	}
-
 	if err != nil {
 		return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
 	}
 
+	image, ok := job.image(strings.TrimSuffix(c.Param("camera"), ".png"))
+
+	if !ok {
+		return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job has no picture from that camera"})
+	}
+
 	if job.Done {
 		c.Response().Header().Set("Cache-Control", "max-age=31536000")
 	} else {
 		c.Response().Header().Set("Cache-Control", "max-age=0")
 	}
 
-	if job.ImageB64Small == "" {
-		job.ImageB64Small, err = shrinkImage(job.ImageB64)
+	width, err := requestedWidth(c)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+	} else if width > 0 {
+		rendition, err := renditionNamed(previewRendition)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		}
+
+		rendition.Width = width
+
+		return serveRendition(c, job, image, previewRendition, rendition)
+	}
+
+	if image.ImageB64Small == "" {
+		image.ImageB64Small, err = shrinkImage(image.ImageB64)
 	}
 
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
 	}
 
-	data, _ := base64.StdEncoding.DecodeString(job.ImageB64Small)
+	data, _ := base64.StdEncoding.DecodeString(image.ImageB64Small)
 
-	return c.Blob(http.StatusOK, "image/png", data)
+	// The preview may be a JPEG despite the name
+	return c.Blob(http.StatusOK, http.DetectContentType(data), data)
+}
+
+// The file name a job's picture from a camera downloads as
+func jobImageFilename(job printJobStatus, image jobImage, kind string) string {
+	if len(job.Images) > 1 {
+		return fmt.Sprintf("%v-%v-%v.png", job.Jobid, image.Camera, kind)
+	}
+
+	return fmt.Sprintf("%v-%v.png", job.Jobid, kind)
 }
 
+// GET /job/:id/original.png, ?camera= for a camera other than the first
 func displayJobImage(c echo.Context) error {
 	job, err := fetchJobCall(c.Param("id"))
 
@@ -371,6 +856,12 @@ func displayJobImage(c echo.Context) error {
 		return c.JSON(http.StatusExpectationFailed, errJSON{Errmsg: err.Error()})
 	}
 
+	image, ok := job.image(c.QueryParam("camera"))
+
+	if !ok {
+		return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job has no picture from that camera"})
+	}
+
 	if job.Done {
 		c.Response().Header().Set("Cache-Control", "max-age=31536000")
 	} else {
@@ -380,11 +871,38 @@ func displayJobImage(c echo.Context) error {
 	c.Response().Header().Set(
 		"Content-Disposition",
 		fmt.Sprintf(
-			"attachment; filename=\"%v-original.png\"",
-			job.Jobid,
+			"attachment; filename=\"%v\"",
+			jobImageFilename(job, image, "original"),
+		))
+
+	data, _ := base64.StdEncoding.DecodeString(image.ImageB64)
+
+	return c.Blob(http.StatusOK, "image/png", data)
+}
+
+// GET /job/:id/raw.png, ?camera= for a camera other than the first
+func displayRawJobImage(c echo.Context) error {
+	job, err := fetchJobCall(c.Param("id"))
+
+	if err != nil {
+		return c.JSON(http.StatusExpectationFailed, errJSON{Errmsg: err.Error()})
+	}
+
+	image, ok := job.image(c.QueryParam("camera"))
+
+	if !ok || image.ImageB64Raw == "" {
+		return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job has no uncropped picture"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "max-age=31536000")
+	c.Response().Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(
+			"attachment; filename=\"%v\"",
+			jobImageFilename(job, image, "raw"),
 		))
 
-	data, _ := base64.StdEncoding.DecodeString(job.ImageB64)
+	data, _ := base64.StdEncoding.DecodeString(image.ImageB64Raw)
 
 	return c.Blob(http.StatusOK, "image/png", data)
 }
@@ -439,12 +957,28 @@ func RunFrontendServer(port int, apiendpoint string) {
 	// Webapp paths
 	e.GET("/home", homePage, loginMiddleware)
 	e.POST("/print", printMedia, loginMiddleware)
+	e.POST("/lint", lintZPLView, loginMiddleware)
+	e.POST("/render", renderZPLView, loginMiddleware)
 	e.GET("/job/:id", displayJob, loginMiddleware, middleware.Gzip())
+	e.DELETE("/job/:id", stopJob, loginMiddleware)
 	e.GET("/job/:id/job.json", displayJobJSON, middleware.Gzip())
 	e.GET("/job/:id/image.png", displaySmallJobImage, middleware.Gzip())
+	e.GET("/job/:id/image/:camera", displaySmallJobImage, middleware.Gzip())
 	e.GET("/job/:id/original.png", displayJobImage, middleware.Gzip())
+	e.GET("/job/:id/raw.png", displayRawJobImage, middleware.Gzip())
+	e.GET("/job/:id/rendition/:name", displayJobRendition)
+	e.GET("/job/:id/diff/:baseline", displayJobDiff)
+	e.GET("/job/:id/timelapse.gif", displayJobTimelapse)
+	e.GET("/job/:id/frames/:frame", displayJobFrame)
+	e.GET("/job/:id/rendered.png", displayJobRendering)
+	e.POST("/job/:id/baseline", setBaseline, loginMiddleware)
+	e.DELETE("/job/:id/baseline", setBaseline, loginMiddleware)
 	e.GET("/job/:id/partial", displayJobPartial, middleware.Gzip())
 
+	e.GET("/printers/:name/live", displayLiveView, loginMiddleware)
+	e.GET("/printers/:name/live.mjpeg", streamLiveView, loginMiddleware)
+	e.POST("/printers/:name/calibrate", calibratePrinterView, loginMiddleware)
+
 	// Serve up static files
 	e.GET("/static/*", echo.WrapHandler(http.FileServer(http.FS(staticContent))))
 
@@ -0,0 +1,160 @@
+package zplorama
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const hostStatusCommand = "~HS"
+
+const (
+	stx = 0x02
+	etx = 0x03
+)
+
+// hostStatus is the parsed response to ~HS
+type hostStatus struct {
+	// String 1
+	PaperOut         bool `json:"paper_out"`
+	Paused           bool `json:"paused"`
+	LabelLength      int  `json:"label_length"`
+	FormatsInBuffer  int  `json:"formats_in_buffer"`
+	BufferFull       bool `json:"buffer_full"`
+	PartialFormat    bool `json:"partial_format"`
+	CorruptRAM       bool `json:"corrupt_ram"`
+	UnderTemperature bool `json:"under_temperature"`
+	OverTemperature  bool `json:"over_temperature"`
+	// String 2
+	HeadOpen        bool `json:"head_open"`
+	RibbonOut       bool `json:"ribbon_out"`
+	ThermalTransfer bool `json:"thermal_transfer"`
+	LabelWaiting    bool `json:"label_waiting"`
+	LabelsRemaining int  `json:"labels_remaining"`
+}
+
+// Pull out the <STX>...<ETX> framed strings in a response
+func framedStrings(response []byte) []string {
+	frames := make([]string, 0)
+
+	for {
+		start := bytes.IndexByte(response, stx)
+		if start == -1 {
+			break
+		}
+
+		end := bytes.IndexByte(response[start:], etx)
+		if end == -1 {
+			break
+		}
+
+		frames = append(frames, string(response[start+1:start+end]))
+		response = response[start+end+1:]
+	}
+
+	return frames
+}
+
+func hostStatusComplete(response []byte) bool {
+	return len(framedStrings(response)) >= 3
+}
+
+func parseHostStatus(response []byte) (*hostStatus, error) {
+	frames := framedStrings(response)
+
+	if len(frames) < 2 {
+		return nil, fmt.Errorf("Host status response is incomplete: %q", string(response))
+	}
+
+	first := strings.Split(frames[0], ",")
+	second := strings.Split(frames[1], ",")
+
+	if len(first) < 12 || len(second) < 9 {
+		return nil, fmt.Errorf("Host status response is malformed: %q", string(response))
+	}
+
+	flag := func(field string) bool {
+		return strings.TrimSpace(field) == "1"
+	}
+
+	number := func(field string) int {
+		value, _ := strconv.Atoi(strings.TrimSpace(field))
+		return value
+	}
+
+	return &hostStatus{
+		PaperOut:         flag(first[1]),
+		Paused:           flag(first[2]),
+		LabelLength:      number(first[3]),
+		FormatsInBuffer:  number(first[4]),
+		BufferFull:       flag(first[5]),
+		PartialFormat:    flag(first[7]),
+		CorruptRAM:       flag(first[9]),
+		UnderTemperature: flag(first[10]),
+		OverTemperature:  flag(first[11]),
+		HeadOpen:         flag(second[2]),
+		RibbonOut:        flag(second[3]),
+		ThermalTransfer:  flag(second[4]),
+		LabelWaiting:     flag(second[7]),
+		LabelsRemaining:  number(second[8]),
+	}, nil
+}
+
+// Problems lists the reasons the printer can't take a job right now
+func (status *hostStatus) Problems() []string {
+	problems := make([]string, 0)
+
+	if status.PaperOut {
+		problems = append(problems, "paper out")
+	}
+	if status.HeadOpen {
+		problems = append(problems, "head open")
+	}
+	if status.Paused {
+		problems = append(problems, "paused")
+	}
+	if status.RibbonOut {
+		problems = append(problems, "ribbon out")
+	}
+	if status.BufferFull {
+		problems = append(problems, "receive buffer full")
+	}
+	if status.CorruptRAM {
+		problems = append(problems, "corrupt RAM")
+	}
+	if status.UnderTemperature {
+		problems = append(problems, "head too cold")
+	}
+	if status.OverTemperature {
+		problems = append(problems, "head too hot")
+	}
+
+	return problems
+}
+
+// Ready reports whether the printer has nothing wrong with it
+func (status *hostStatus) Ready() bool {
+	return len(status.Problems()) == 0
+}
+
+func queryHostStatus(printer PrinterTransport) (*hostStatus, error) {
+	querier, ok := printer.(PrinterQuerier)
+
+	if !ok {
+		return nil, errQueryUnsupported
+	}
+
+	response, err := querier.Query(hostStatusCommand, hostStatusComplete)
+
+	if err != nil && !hostStatusComplete(response) {
+		return nil, err
+	}
+
+	return parseHostStatus(response)
+}
+
+func notReadyError(status *hostStatus) error {
+	return errors.New("Printer not ready: " + strings.Join(status.Problems(), ", "))
+}
@@ -0,0 +1,63 @@
+package zplorama
+
+import (
+	"strings"
+	"testing"
+)
+
+// A ~HS response with the given first two strings
+func hostStatusResponse(first, second string) []byte {
+	return []byte("\x02" + first + "\x03\r\n\x02" + second + "\x03\r\n\x021234,0\x03\r\n")
+}
+
+func TestParseHostStatus(t *testing.T) {
+	cases := []struct {
+		first, second string
+		want          hostStatus
+		problems      []string
+	}{
+		{"030,0,0,1218,000,0,0,0,000,0,0,0", "001,0,0,0,0,0,0,0,00000000,1,000", hostStatus{LabelLength: 1218}, nil},
+		{"030,1,0,1218,000,0,0,0,000,0,0,0", "001,0,0,0,0,0,0,0,00000000,1,000", hostStatus{PaperOut: true, LabelLength: 1218}, []string{"paper out"}},
+		{"030,0,1,1218,000,0,0,0,000,0,0,0", "001,0,0,0,0,0,0,0,00000000,1,000", hostStatus{Paused: true, LabelLength: 1218}, []string{"paused"}},
+		{"030,0,0,1218,000,0,0,0,000,0,0,0", "001,0,1,0,0,0,0,0,00000000,1,000", hostStatus{HeadOpen: true, LabelLength: 1218}, []string{"head open"}},
+		{"030,1,1,1218,000,0,0,0,000,0,0,0", "001,0,1,1,0,0,0,0,00000000,1,000", hostStatus{PaperOut: true, Paused: true, HeadOpen: true, RibbonOut: true, LabelLength: 1218}, []string{"paper out", "head open", "paused", "ribbon out"}},
+		{"030,0,0,0812,002,0,0,1,000,0,0,0", "001,0,0,0,1,0,0,1,00000003,1,000", hostStatus{LabelLength: 812, FormatsInBuffer: 2, PartialFormat: true, ThermalTransfer: true, LabelWaiting: true, LabelsRemaining: 3}, nil},
+		{"030,0,0,1218,000,1,0,0,000,1,1,1", "001,0,0,0,0,0,0,0,00000000,1,000", hostStatus{LabelLength: 1218, BufferFull: true, CorruptRAM: true, UnderTemperature: true, OverTemperature: true}, []string{"receive buffer full", "corrupt RAM", "head too cold", "head too hot"}},
+	}
+
+	for _, test := range cases {
+		got, err := parseHostStatus(hostStatusResponse(test.first, test.second))
+
+		if err != nil {
+			t.Errorf("%q %q: %v", test.first, test.second, err)
+			continue
+		}
+
+		if *got != test.want {
+			t.Errorf("%q %q: got %+v, want %+v", test.first, test.second, *got, test.want)
+		}
+
+		if problems := got.Problems(); strings.Join(problems, ", ") != strings.Join(test.problems, ", ") {
+			t.Errorf("%q %q: got problems %q, want %q", test.first, test.second, problems, test.problems)
+		}
+
+		if got.Ready() != (len(test.problems) == 0) {
+			t.Errorf("%q %q: got ready %v, want %v", test.first, test.second, got.Ready(), len(test.problems) == 0)
+		}
+	}
+}
+
+func TestParseHostStatusMalformed(t *testing.T) {
+	cases := []string{
+		"",
+		"\x02030,0,0,1218,000,0,0,0,000,0,0,0\x03",
+		"\x02030,0,0\x03\x02001,0,0\x03\x021234,0\x03",
+		"030,0,0,1218,000,0,0,0,000,0,0,0\r\n001,0,0,0,0,0,0,0,00000000,1,000\r\n",
+	}
+
+	for _, response := range cases {
+		if got, err := parseHostStatus([]byte(response)); err == nil {
+			t.Errorf("%q: got %+v, want an error", response, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package zplorama
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// A picture from one of the printer's cameras, before and after cropping to the label
+type cameraCapture struct {
+	camera  string
+	raw     []byte
+	label   []byte
+	cropped bool
+	// Drawn from the ZPL rather than photographed
+	rendered bool
+	started  time.Time
+	elapsed  time.Duration
+	// Set if the camera couldn't take its picture
+	err error
+}
+
+// The picture from one of the job's cameras, "" for the first. Jobs that
+// never got any pictures get a placeholder instead.
+func (job *printJobStatus) image(camera string) (jobImage, bool) {
+	for _, image := range job.Images {
+		if camera == "" || image.Camera == camera {
+			return image, true
+		}
+	}
+
+	if len(job.Images) > 0 {
+		return jobImage{}, false
+	}
+
+	placeholder := emptyPNG
+	if job.Done {
+		placeholder = sadFace
+	}
+
+	return jobImage{Camera: camera, ImageB64: placeholder, ImageB64Small: placeholder}, true
+}
+
+// Take a picture with every camera, in order. A camera that fails gets a
+// capture holding its error and the rest carry on; the error is only
+// returned if it was the first camera, whose picture gets checked.
+func (worker *printerWorker) captureAll(status *printJobStatus) ([]cameraCapture, error) {
+	captures := make([]cameraCapture, 0, len(worker.cameras))
+	var firstErr error
+
+	for index, camera := range worker.cameras {
+		started := time.Now()
+		picture, err := camera.camera.Capture()
+		elapsed := time.Since(started)
+
+		if err != nil {
+			if len(worker.cameras) > 1 {
+				err = fmt.Errorf("Camera %v: %v", camera.name, err)
+			}
+
+			if index == 0 {
+				firstErr = err
+			}
+
+			status.Log = append(status.Log, fmt.Sprintf("Could not capture: %v", err))
+			captures = append(captures, cameraCapture{camera: camera.name, started: started, elapsed: elapsed, err: err})
+			continue
+		}
+
+		status.Log = append(status.Log, fmt.Sprintf("Capture from camera %v took %vms", camera.name, elapsed.Milliseconds()))
+
+		capture := cameraCapture{camera: camera.name, raw: picture, label: picture, rendered: camera.rendered, started: started, elapsed: elapsed}
+
+		// Keep the whole frame around next to the cropped label
+		if camera.crop != nil {
+			label, err := camera.crop.apply(picture)
+
+			if err != nil {
+				status.Log = append(status.Log, fmt.Sprintf("Could not crop picture from camera %v to label: %v", camera.name, err))
+			} else {
+				capture.label = label
+				capture.cropped = true
+			}
+		}
+
+		captures = append(captures, capture)
+	}
+
+	return captures, firstErr
+}
+
+// Encode a capture, with its preview, for storing with the job
+func (capture cameraCapture) jobImage() (jobImage, error) {
+	image := jobImage{
+		Camera:         capture.camera,
+		ImageB64:       base64.StdEncoding.EncodeToString(capture.label),
+		CaptureStarted: capture.started.Format(time.RFC3339Nano),
+		CaptureMillis:  capture.elapsed.Milliseconds(),
+	}
+
+	if capture.err != nil {
+		image.ImageB64 = sadFace
+		image.ImageB64Small = sadFace
+		image.Error = capture.err.Error()
+
+		return image, nil
+	}
+
+	if capture.cropped {
+		image.ImageB64Raw = base64.StdEncoding.EncodeToString(capture.raw)
+	}
+
+	var err error
+	image.ImageB64Small, err = shrinkImage(image.ImageB64)
+
+	return image, err
+}
+
+// How jobs stored their one picture before printers could have more than one camera
+type legacyJobImage struct {
+	Printer        string     `json:"printer"`
+	ImageB64       string     `json:"image"`
+	ImageB64Small  string     `json:"image_small"`
+	ImageB64Raw    string     `json:"image_raw"`
+	CaptureStarted string     `json:"capture_started"`
+	CaptureMillis  int64      `json:"capture_ms"`
+	Images         []jobImage `json:"images"`
+}
+
+// Move pictures stored the old way into the job's list of images, as
+// taken by the first camera of the printer the job ran on
+func migrateJobImages(database *bolt.DB, workers map[string]*printerWorker) error {
+	migrated := 0
+
+	err := database.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(printjobTable))
+		updates := make(map[string][]byte)
+
+		err := bucket.ForEach(func(key, value []byte) error {
+			var legacy legacyJobImage
+
+			if err := json5.Unmarshal(value, &legacy); err != nil || legacy.ImageB64 == "" || len(legacy.Images) > 0 {
+				return nil
+			}
+
+			var job printJobStatus
+
+			if err := json5.Unmarshal(value, &job); err != nil {
+				return nil
+			}
+
+			// Placeholders aren't worth keeping; the job serves them anyway
+			if legacy.ImageB64 != emptyPNG && legacy.ImageB64 != sadFace {
+				camera := defaultCameraName
+				if worker, ok := workers[legacy.Printer]; ok {
+					camera = worker.primaryCamera().name
+				}
+
+				job.Images = []jobImage{{
+					Camera:         camera,
+					ImageB64:       legacy.ImageB64,
+					ImageB64Small:  legacy.ImageB64Small,
+					ImageB64Raw:    legacy.ImageB64Raw,
+					CaptureStarted: legacy.CaptureStarted,
+					CaptureMillis:  legacy.CaptureMillis,
+				}}
+			}
+
+			recordBytes, err := json5.Marshal(&job)
+
+			if err != nil {
+				return err
+			}
+
+			updates[string(key)] = recordBytes
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for key, recordBytes := range updates {
+			if err := bucket.Put([]byte(key), recordBytes); err != nil {
+				return err
+			}
+		}
+
+		migrated = len(updates)
+
+		return nil
+	})
+
+	if err == nil && migrated > 0 {
+		log.Printf("Moved %v jobs' pictures into per-camera images", migrated)
+	}
+
+	return err
+}
@@ -0,0 +1,344 @@
+package zplorama
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Width auto-detection works at; plenty to find a label and a lot cheaper than full size
+const labelDetectWidth = 400
+
+var errNoLabelFound = errors.New("Could not find a label in the picture")
+
+// A point in a camera frame, in pixels
+type framePoint struct {
+	X, Y float64
+}
+
+// How to turn a camera frame into a flat picture of just the label
+type labelCrop struct {
+	// Top left, top right, bottom right, bottom left
+	corners []framePoint
+	auto    bool
+}
+
+// The camera's crop settings, or nil if it doesn't want any
+func newLabelCrop(config cameraConfig) (*labelCrop, error) {
+	if len(config.Corners) == 0 && !config.AutoCrop {
+		return nil, nil
+	} else if len(config.Corners) != 0 && len(config.Corners) != 4 {
+		return nil, fmt.Errorf("Camera corners should be 4 points, not %v", len(config.Corners))
+	}
+
+	crop := &labelCrop{auto: config.AutoCrop}
+
+	for _, corner := range config.Corners {
+		if len(corner) != 2 {
+			return nil, fmt.Errorf("Camera corner %v should be [x, y]", corner)
+		}
+
+		crop.corners = append(crop.corners, framePoint{corner[0], corner[1]})
+	}
+
+	return crop, nil
+}
+
+// Straighten out and crop a PNG from the camera
+func (crop *labelCrop) apply(data []byte) ([]byte, error) {
+	picture, err := imaging.Decode(bytes.NewBuffer(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(crop.corners) == 4 {
+		picture = correctPerspective(picture, crop.corners)
+	}
+
+	if crop.auto {
+		corners, err := findLabelCorners(picture)
+
+		if err != nil {
+			return nil, err
+		}
+
+		picture = correctPerspective(picture, corners)
+	}
+
+	var labelImage bytes.Buffer
+	err = imaging.Encode(&labelImage, picture, imaging.PNG)
+
+	return labelImage.Bytes(), err
+}
+
+// Projective transform taking the unit square onto a quadrilateral
+type squareToQuad struct {
+	a, b, c, d, e, f, g, h float64
+}
+
+// After Heckbert, "Fundamentals of Texture Mapping and Image Warping"
+func newSquareToQuad(corners []framePoint) squareToQuad {
+	x0, y0 := corners[0].X, corners[0].Y
+	x1, y1 := corners[1].X, corners[1].Y
+	x2, y2 := corners[2].X, corners[2].Y
+	x3, y3 := corners[3].X, corners[3].Y
+
+	sx := x0 - x1 + x2 - x3
+	sy := y0 - y1 + y2 - y3
+
+	if sx == 0 && sy == 0 {
+		return squareToQuad{a: x1 - x0, b: x3 - x0, c: x0, d: y1 - y0, e: y3 - y0, f: y0}
+	}
+
+	dx1, dx2 := x1-x2, x3-x2
+	dy1, dy2 := y1-y2, y3-y2
+	det := dx1*dy2 - dx2*dy1
+
+	g := (sx*dy2 - dx2*sy) / det
+	h := (dx1*sy - sx*dy1) / det
+
+	return squareToQuad{
+		a: x1 - x0 + g*x1, b: x3 - x0 + h*x3, c: x0,
+		d: y1 - y0 + g*y1, e: y3 - y0 + h*y3, f: y0,
+		g: g, h: h,
+	}
+}
+
+func (transform squareToQuad) apply(s, t float64) (float64, float64) {
+	w := transform.g*s + transform.h*t + 1
+
+	return (transform.a*s + transform.b*t + transform.c) / w,
+		(transform.d*s + transform.e*t + transform.f) / w
+}
+
+// The unit square coordinates that land on x, y; apply backwards
+func (transform squareToQuad) invert(x, y float64) (float64, float64) {
+	a, b, c := transform.a, transform.b, transform.c
+	d, e, f := transform.d, transform.e, transform.f
+	g, h := transform.g, transform.h
+
+	w := (d*h-e*g)*x + (b*g-a*h)*y + (a*e - b*d)
+
+	return ((e-f*h)*x + (c*h-b)*y + (b*f - c*e)) / w,
+		((f*g-d)*x + (a-c*g)*y + (c*d - a*f)) / w
+}
+
+func distance(a, b framePoint) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// Map the quadrilateral at corners onto a rectangle about the same size
+func correctPerspective(picture image.Image, corners []framePoint) image.Image {
+	width := int(math.Max(distance(corners[0], corners[1]), distance(corners[3], corners[2])))
+	height := int(math.Max(distance(corners[0], corners[3]), distance(corners[1], corners[2])))
+
+	if width < 1 || height < 1 {
+		return picture
+	}
+
+	source := imaging.Clone(picture)
+	transform := newSquareToQuad(corners)
+	flattened := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sourceX, sourceY := transform.apply((float64(x)+0.5)/float64(width), (float64(y)+0.5)/float64(height))
+			flattened.SetNRGBA(x, y, sampleBilinear(source, sourceX-0.5, sourceY-0.5))
+		}
+	}
+
+	return flattened
+}
+
+func sampleBilinear(picture *image.NRGBA, x, y float64) color.NRGBA {
+	bounds := picture.Bounds()
+	clamp := func(value, low, high int) int {
+		if value < low {
+			return low
+		} else if value > high {
+			return high
+		}
+		return value
+	}
+
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	left, right := clamp(x0, bounds.Min.X, bounds.Max.X-1), clamp(x0+1, bounds.Min.X, bounds.Max.X-1)
+	top, bottom := clamp(y0, bounds.Min.Y, bounds.Max.Y-1), clamp(y0+1, bounds.Min.Y, bounds.Max.Y-1)
+
+	topLeft, topRight := picture.NRGBAAt(left, top), picture.NRGBAAt(right, top)
+	bottomLeft, bottomRight := picture.NRGBAAt(left, bottom), picture.NRGBAAt(right, bottom)
+
+	mix := func(tl, tr, bl, br uint8) uint8 {
+		upper := float64(tl)*(1-fx) + float64(tr)*fx
+		lower := float64(bl)*(1-fx) + float64(br)*fx
+		return uint8(math.Round(upper*(1-fy) + lower*fy))
+	}
+
+	return color.NRGBA{
+		R: mix(topLeft.R, topRight.R, bottomLeft.R, bottomRight.R),
+		G: mix(topLeft.G, topRight.G, bottomLeft.G, bottomRight.G),
+		B: mix(topLeft.B, topRight.B, bottomLeft.B, bottomRight.B),
+		A: mix(topLeft.A, topRight.A, bottomLeft.A, bottomRight.A),
+	}
+}
+
+// Threshold between dark and light that best splits a brightness histogram (Otsu's method)
+func otsuThreshold(histogram [256]int, total int) uint8 {
+	sum := 0.0
+	for level, count := range histogram {
+		sum += float64(level * count)
+	}
+
+	var best uint8
+	var bestVariance, backgroundSum float64
+	backgroundCount := 0
+
+	for level, count := range histogram {
+		backgroundCount += count
+		foregroundCount := total - backgroundCount
+
+		if backgroundCount == 0 {
+			continue
+		} else if foregroundCount == 0 {
+			break
+		}
+
+		backgroundSum += float64(level * count)
+		backgroundMean := backgroundSum / float64(backgroundCount)
+		foregroundMean := (sum - backgroundSum) / float64(foregroundCount)
+
+		variance := float64(backgroundCount) * float64(foregroundCount) * (backgroundMean - foregroundMean) * (backgroundMean - foregroundMean)
+
+		if variance > bestVariance {
+			bestVariance = variance
+			best = uint8(level)
+		}
+	}
+
+	return best
+}
+
+// A picture scaled down to at most detectWidth, blurred and grayscaled for
+// finding shapes in, with the threshold between its dark and light and how
+// much it was scaled down by
+func detectionImage(picture image.Image, detectWidth int, blur float64) (*image.NRGBA, uint8, float64) {
+	scale := 1.0
+
+	if picture.Bounds().Dx() > detectWidth {
+		scale = float64(picture.Bounds().Dx()) / float64(detectWidth)
+		picture = imaging.Resize(picture, detectWidth, 0, imaging.Box)
+	}
+
+	gray := imaging.Grayscale(imaging.Blur(picture, blur))
+
+	var histogram [256]int
+	for i := 0; i < len(gray.Pix); i += 4 {
+		histogram[gray.Pix[i]]++
+	}
+
+	return gray, otsuThreshold(histogram, gray.Bounds().Dx()*gray.Bounds().Dy()), scale
+}
+
+// Every 4-connected group of pixels for which inside is true, each a list of
+// y*width+x indexes
+func connectedBlobs(width, height int, inside func(x, y int) bool) [][]int {
+	seen := make([]bool, width*height)
+	var blobs [][]int
+
+	for start := range seen {
+		if seen[start] || !inside(start%width, start/width) {
+			continue
+		}
+
+		// Flood fill the blob
+		blob := []int{start}
+		seen[start] = true
+
+		for next := 0; next < len(blob); next++ {
+			x, y := blob[next]%width, blob[next]/width
+
+			for _, neighbor := range [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				nx, ny := neighbor[0], neighbor[1]
+
+				if nx < 0 || ny < 0 || nx >= width || ny >= height {
+					continue
+				}
+
+				index := ny*width + nx
+				if !seen[index] && inside(nx, ny) {
+					seen[index] = true
+					blob = append(blob, index)
+				}
+			}
+		}
+
+		blobs = append(blobs, blob)
+	}
+
+	return blobs
+}
+
+// Find the biggest bright blob in the picture (labels are white, printers
+// and desks mostly aren't) and return its outermost corners
+func findLabelCorners(picture image.Image) ([]framePoint, error) {
+	gray, threshold, scale := detectionImage(picture, labelDetectWidth, 1)
+	width, height := gray.Bounds().Dx(), gray.Bounds().Dy()
+
+	bright := func(x, y int) bool {
+		return gray.Pix[y*gray.Stride+x*4] > threshold
+	}
+
+	var biggest []int
+	for _, blob := range connectedBlobs(width, height, bright) {
+		if len(blob) > len(biggest) {
+			biggest = blob
+		}
+	}
+
+	// Anything smaller than this is a reflection, not a label
+	if len(biggest) < width*height/50 {
+		return nil, errNoLabelFound
+	}
+
+	// The pixels furthest along each diagonal are the corners, which holds
+	// up for labels that are a bit skewed or rotated
+	topLeft, topRight, bottomRight, bottomLeft := biggest[0], biggest[0], biggest[0], biggest[0]
+	for _, index := range biggest {
+		x, y := index%width, index/width
+		tlx, tly := topLeft%width, topLeft/width
+		trx, try := topRight%width, topRight/width
+		brx, bry := bottomRight%width, bottomRight/width
+		blx, bly := bottomLeft%width, bottomLeft/width
+
+		if x+y < tlx+tly {
+			topLeft = index
+		}
+		if x-y > trx-try {
+			topRight = index
+		}
+		if x+y > brx+bry {
+			bottomRight = index
+		}
+		if x-y < blx-bly {
+			bottomLeft = index
+		}
+	}
+
+	corners := make([]framePoint, 0, 4)
+	for _, index := range []int{topLeft, topRight, bottomRight, bottomLeft} {
+		corners = append(corners, framePoint{
+			X: (float64(index%width) + 0.5) * scale,
+			Y: (float64(index/width) + 0.5) * scale,
+		})
+	}
+
+	return corners, nil
+}
@@ -0,0 +1,79 @@
+package zplorama
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jasonbot/zpl-o-rama/v1/zpl"
+	"github.com/labstack/echo"
+)
+
+// What makes the frontend refuse to print ZPL the linter has doubts about
+const (
+	lintRejectErrors   = "errors"
+	lintRejectWarnings = "warnings"
+	lintRejectNever    = "never"
+)
+
+type lintResponse struct {
+	Diagnostics []zpl.Diagnostic `json:"diagnostics"`
+	Errors      bool             `json:"errors"`
+}
+
+// A print request turned away because of what the linter found
+type lintRejection struct {
+	Errmsg      string           `json:"error"`
+	Diagnostics []zpl.Diagnostic `json:"diagnostics"`
+}
+
+// The media size in dots the printer's labels are checked against
+func (worker *printerWorker) lintOptions(media string) zpl.LintOptions {
+	options := worker.renderOptions(media)
+
+	return zpl.LintOptions{Width: options.Width, Length: options.Length}
+}
+
+// Whether diagnostics are bad enough to not print, going by lint_reject
+func lintRejects(diagnostics []zpl.Diagnostic) (bool, error) {
+	switch strings.ToLower(Config.LintReject) {
+	case "", lintRejectErrors:
+		return zpl.HasErrors(diagnostics), nil
+	case lintRejectWarnings:
+		return len(diagnostics) > 0, nil
+	case lintRejectNever:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("Unknown lint_reject setting %v", Config.LintReject)
+}
+
+// POST /lint checks ZPL against the printer and media it would print on
+func lintZPL(workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		lintRequest := new(printJobRequest)
+		c.Bind(lintRequest)
+
+		if lintRequest.Printer == "" {
+			lintRequest.Printer = defaultPrinter(workers)
+		}
+
+		worker, ok := workers[lintRequest.Printer]
+
+		if !ok {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: fmt.Sprintf("Unknown printer %v", lintRequest.Printer)})
+		}
+
+		if _, err := mediaProfileNamed(worker.mediaName(lintRequest.Media)); err != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+		}
+
+		diagnostics := zpl.Lint(lintRequest.ZPL, worker.lintOptions(lintRequest.Media))
+
+		if diagnostics == nil {
+			diagnostics = []zpl.Diagnostic{}
+		}
+
+		return c.JSON(http.StatusOK, lintResponse{Diagnostics: diagnostics, Errors: zpl.HasErrors(diagnostics)})
+	}
+}
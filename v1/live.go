@@ -0,0 +1,208 @@
+package zplorama
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/labstack/echo"
+)
+
+const (
+	liveBoundary    = "liveframe"
+	liveJPEGQuality = 70
+	// A viewer that can't keep up misses frames rather than holding everyone else up
+	liveViewerBuffer = 2
+)
+
+// Camera access for one printer; only one picture gets taken at a time so a
+// live viewer can't fight a print job over the device
+type serializedCamera struct {
+	lock sync.Mutex
+	Camera
+}
+
+func (camera *serializedCamera) Capture() ([]byte, error) {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	return camera.Camera.Capture()
+}
+
+// One feed of frames per camera, shared by however many people are watching
+type liveFeed struct {
+	lock    sync.Mutex
+	viewers map[chan []byte]bool
+	running bool
+}
+
+func (camera *printerCamera) watchLive() chan []byte {
+	camera.live.lock.Lock()
+	defer camera.live.lock.Unlock()
+
+	if camera.live.viewers == nil {
+		camera.live.viewers = make(map[chan []byte]bool)
+	}
+
+	viewer := make(chan []byte, liveViewerBuffer)
+	camera.live.viewers[viewer] = true
+
+	if !camera.live.running {
+		camera.live.running = true
+		go camera.runLiveFeed()
+	}
+
+	return viewer
+}
+
+func (camera *printerCamera) stopWatchingLive(viewer chan []byte) {
+	camera.live.lock.Lock()
+	defer camera.live.lock.Unlock()
+
+	delete(camera.live.viewers, viewer)
+}
+
+// Hand a frame (if there is one) to everyone watching; false once nobody is
+func (camera *printerCamera) broadcastLive(frame []byte) bool {
+	camera.live.lock.Lock()
+	defer camera.live.lock.Unlock()
+
+	if len(camera.live.viewers) == 0 {
+		camera.live.running = false
+		return false
+	}
+
+	if frame == nil {
+		return true
+	}
+
+	for viewer := range camera.live.viewers {
+		select {
+		case viewer <- frame:
+		default:
+		}
+	}
+
+	return true
+}
+
+// Next frame for the live view as a JPEG: streaming cameras already have
+// one on the way, anything else has to take a picture
+func (camera *printerCamera) nextLiveFrame(last time.Time) ([]byte, time.Time, error) {
+	if stream, ok := camera.camera.Camera.(*streamCamera); ok {
+		stream.Start()
+
+		for {
+			frame, frameTime, next := stream.latestFrame()
+
+			if frame != nil && frameTime.After(last) {
+				return frame, frameTime, nil
+			}
+
+			select {
+			case <-next:
+			case <-time.After(stream.timeout):
+				return nil, last, fmt.Errorf("Camera stream did not deliver a frame in time")
+			}
+		}
+	}
+
+	interval := parseDurationOr(Config.LiveInterval, time.Second)
+	time.Sleep(time.Until(last.Add(interval)))
+
+	taken := time.Now()
+	picture, err := camera.camera.Capture()
+
+	if err != nil {
+		return nil, taken, err
+	}
+
+	decoded, err := imaging.Decode(bytes.NewBuffer(picture))
+
+	if err != nil {
+		return nil, taken, err
+	}
+
+	var frame bytes.Buffer
+	err = imaging.Encode(&frame, decoded, imaging.JPEG, imaging.JPEGQuality(liveJPEGQuality))
+
+	return frame.Bytes(), taken, err
+}
+
+func (camera *printerCamera) runLiveFeed() {
+	var last time.Time
+
+	for {
+		frame, frameTime, err := camera.nextLiveFrame(last)
+		last = frameTime
+
+		if err != nil {
+			log.Printf("Live view from camera %v: %v", camera.name, err)
+			time.Sleep(time.Second)
+
+			// Still stop once nobody's watching
+			if !camera.broadcastLive(nil) {
+				return
+			}
+
+			continue
+		}
+
+		if !camera.broadcastLive(frame) {
+			return
+		}
+	}
+}
+
+// GET /printers/:name/live serves one of the printer's cameras (?camera=,
+// the first by default) as an MJPEG stream
+func getLiveView(workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		worker, ok := workers[c.Param("name")]
+
+		if !ok {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Printer not found"})
+		}
+
+		camera, ok := worker.cameraNamed(c.QueryParam("camera"))
+
+		if !ok {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Camera not found"})
+		}
+
+		viewer := camera.watchLive()
+		defer camera.stopWatchingLive(viewer)
+
+		response := c.Response()
+		response.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+liveBoundary)
+		response.Header().Set("Cache-Control", "no-cache, no-store")
+		response.WriteHeader(http.StatusOK)
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case frame := <-viewer:
+				_, err := fmt.Fprintf(response, "--%v\r\nContent-Type: image/jpeg\r\nContent-Length: %v\r\n\r\n", liveBoundary, len(frame))
+
+				if err == nil {
+					_, err = response.Write(frame)
+				}
+
+				if err == nil {
+					_, err = response.Write([]byte("\r\n"))
+				}
+
+				if err != nil {
+					return nil
+				}
+
+				response.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package zplorama
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+const defaultMediaName = "4x6"
+
+// The 4x6" direct thermal labels everything used to assume
+var defaultMediaProfile = mediaProfile{
+	Width:       4,
+	Length:      6,
+	Orientation: "N",
+	Encoding:    "utf-8",
+}
+
+// ^CI values for the encodings people actually use
+var mediaEncodings = map[string]int{
+	"usa1":     0,
+	"cp850":    13,
+	"cp1252":   27,
+	"utf-8":    28,
+	"utf-16be": 29,
+	"utf-16le": 30,
+}
+
+type mediaListing struct {
+	Name    string       `json:"name"`
+	Profile mediaProfile `json:"profile"`
+}
+
+func mediaProfileNamed(name string) (mediaProfile, error) {
+	if name == "" {
+		name = defaultMediaName
+	}
+
+	if profile, ok := Config.MediaProfiles[name]; ok {
+		return profile, nil
+	} else if name == defaultMediaName {
+		return defaultMediaProfile, nil
+	}
+
+	return mediaProfile{}, fmt.Errorf("Unknown media profile %v", name)
+}
+
+func (profile *mediaProfile) encodingNumber() (int, error) {
+	encoding := strings.ToLower(profile.Encoding)
+
+	if encoding == "" {
+		return mediaEncodings["utf-8"], nil
+	} else if number, ok := mediaEncodings[encoding]; ok {
+		return number, nil
+	} else if number, err := strconv.Atoi(encoding); err == nil && number >= 0 && number <= 36 {
+		return number, nil
+	}
+
+	return 0, fmt.Errorf("Unknown media encoding %v", profile.Encoding)
+}
+
+func (profile *mediaProfile) validate() error {
+	if profile.Width <= 0 || profile.Length <= 0 {
+		return fmt.Errorf("Media size %vx%v is invalid", profile.Width, profile.Length)
+	}
+
+	switch strings.ToUpper(profile.Orientation) {
+	case "", "N", "R", "I", "B":
+	default:
+		return fmt.Errorf("Unknown media orientation %v", profile.Orientation)
+	}
+
+	switch strings.ToLower(profile.MediaType) {
+	case "", "direct", "transfer":
+	default:
+		return fmt.Errorf("Unknown media type %v", profile.MediaType)
+	}
+
+	if profile.Darkness < 0 || profile.Darkness > 30 {
+		return fmt.Errorf("Darkness %v is out of range (0-30)", profile.Darkness)
+	}
+
+	_, err := profile.encodingNumber()
+
+	return err
+}
+
+// The profile's own DPI wins over the printer's
+func (profile *mediaProfile) dpi(printerDPI int) int {
+	if profile.DPI > 0 {
+		return profile.DPI
+	} else if printerDPI > 0 {
+		return printerDPI
+	}
+
+	return defaultDPI
+}
+
+// Media size in dots: width, length
+func (profile *mediaProfile) dots(printerDPI int) (int, int) {
+	dpi := float64(profile.dpi(printerDPI))
+
+	return int(profile.Width * dpi), int(profile.Length * dpi)
+}
+
+// Preamble sent ahead of every job to put the printer into a known state
+func (profile *mediaProfile) resetCommand(printerDPI int) (string, error) {
+	width, length := profile.dots(printerDPI)
+	encoding, err := profile.encodingNumber()
+
+	if err != nil {
+		return "", err
+	}
+
+	orientation := strings.ToUpper(profile.Orientation)
+	if orientation == "" {
+		orientation = "N"
+	}
+
+	lines := []string{
+		"^XA",
+		"^FW" + orientation,
+		fmt.Sprintf("^LL%v", length),
+		fmt.Sprintf("^PW%v", width),
+		"^PON",
+		"^LH0,0",
+		"^LT0",
+	}
+
+	switch strings.ToLower(profile.MediaType) {
+	case "direct":
+		lines = append(lines, "^MTD")
+	case "transfer":
+		lines = append(lines, "^MTT")
+	}
+
+	if profile.PrintSpeed > 0 {
+		lines = append(lines, fmt.Sprintf("^PR%v", profile.PrintSpeed))
+	}
+
+	if profile.Darkness > 0 {
+		lines = append(lines, fmt.Sprintf("~SD%02d", profile.Darkness))
+	}
+
+	lines = append(lines, fmt.Sprintf("^CI%v", encoding), "^XZ")
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func listMedia(c echo.Context) error {
+	names := make([]string, 0, len(Config.MediaProfiles)+1)
+
+	for name := range Config.MediaProfiles {
+		names = append(names, name)
+	}
+
+	if _, ok := Config.MediaProfiles[defaultMediaName]; !ok {
+		names = append(names, defaultMediaName)
+	}
+
+	sort.Strings(names)
+
+	media := make([]mediaListing, 0, len(names))
+
+	for _, name := range names {
+		profile, _ := mediaProfileNamed(name)
+		media = append(media, mediaListing{Name: name, Profile: profile})
+	}
+
+	return c.JSON(http.StatusOK, media)
+}
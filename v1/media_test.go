@@ -0,0 +1,63 @@
+package zplorama
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMediaResetCommand(t *testing.T) {
+	cases := []struct {
+		profile    mediaProfile
+		printerDPI int
+		want       []string
+	}{
+		{defaultMediaProfile, 0, []string{"^XA", "^FWN", "^LL1218", "^PW812", "^PON", "^LH0,0", "^LT0", "^CI28", "^XZ"}},
+		{defaultMediaProfile, 300, []string{"^XA", "^FWN", "^LL1800", "^PW1200", "^PON", "^LH0,0", "^LT0", "^CI28", "^XZ"}},
+		// The profile's DPI wins over the printer's
+		{mediaProfile{Width: 2, Length: 1, DPI: 300}, 203, []string{"^XA", "^FWN", "^LL300", "^PW600", "^PON", "^LH0,0", "^LT0", "^CI28", "^XZ"}},
+		{mediaProfile{Width: 2.25, Length: 1.25, Orientation: "r", Encoding: "CP1252"}, 203, []string{"^XA", "^FWR", "^LL253", "^PW456", "^PON", "^LH0,0", "^LT0", "^CI27", "^XZ"}},
+		{mediaProfile{Width: 4, Length: 6, MediaType: "transfer", PrintSpeed: 4, Darkness: 5, Encoding: "13"}, 203, []string{"^XA", "^FWN", "^LL1218", "^PW812", "^PON", "^LH0,0", "^LT0", "^MTT", "^PR4", "~SD05", "^CI13", "^XZ"}},
+		{mediaProfile{Width: 4, Length: 6, MediaType: "Direct", Darkness: 30}, 203, []string{"^XA", "^FWN", "^LL1218", "^PW812", "^PON", "^LH0,0", "^LT0", "^MTD", "~SD30", "^CI28", "^XZ"}},
+	}
+
+	for _, test := range cases {
+		got, err := test.profile.resetCommand(test.printerDPI)
+		want := strings.Join(test.want, "\n") + "\n"
+
+		if err != nil {
+			t.Errorf("%+v: %v", test.profile, err)
+		} else if got != want {
+			t.Errorf("%+v at %v dpi: got %q, want %q", test.profile, test.printerDPI, got, want)
+		}
+	}
+}
+
+func TestMediaValidate(t *testing.T) {
+	cases := []struct {
+		profile mediaProfile
+		// Start of the error message, or "" for a valid profile
+		want string
+	}{
+		{defaultMediaProfile, ""},
+		{mediaProfile{Width: 2, Length: 1, DPI: 300, Darkness: 30, MediaType: "TRANSFER", Orientation: "b", Encoding: "utf-16le"}, ""},
+		{mediaProfile{Width: 4, Length: 6, Encoding: "36"}, ""},
+		{mediaProfile{Width: 0, Length: 6}, "Media size 0x6 is invalid"},
+		{mediaProfile{Width: 4, Length: -1}, "Media size 4x-1 is invalid"},
+		{mediaProfile{Width: 4, Length: 6, Orientation: "X"}, "Unknown media orientation X"},
+		{mediaProfile{Width: 4, Length: 6, MediaType: "thermal"}, "Unknown media type thermal"},
+		{mediaProfile{Width: 4, Length: 6, Darkness: 31}, "Darkness 31 is out of range"},
+		{mediaProfile{Width: 4, Length: 6, Darkness: -1}, "Darkness -1 is out of range"},
+		{mediaProfile{Width: 4, Length: 6, Encoding: "latin9"}, "Unknown media encoding latin9"},
+		{mediaProfile{Width: 4, Length: 6, Encoding: "37"}, "Unknown media encoding 37"},
+	}
+
+	for _, test := range cases {
+		err := test.profile.validate()
+
+		if test.want == "" && err != nil {
+			t.Errorf("%+v: got %v, want no error", test.profile, err)
+		} else if test.want != "" && (err == nil || !strings.HasPrefix(err.Error(), test.want)) {
+			t.Errorf("%+v: got %v, want %q", test.profile, err, test.want)
+		}
+	}
+}
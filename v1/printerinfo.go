@@ -0,0 +1,244 @@
+package zplorama
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const hostIdentificationCommand = "~HI"
+
+// SGD variables to ask every printer for, on top of Config.PrinterInfoVars
+var defaultPrinterInfoVars = []string{
+	"device.product_name",
+	"device.friendly_name",
+	"device.unique_id",
+	"appl.name",
+	"head.resolution.in_dpi",
+	"media.printmode",
+}
+
+// Dots per millimeter (as ~HI reports it) to the DPI everyone actually quotes
+var dotsPerMMToDPI = map[int]int{
+	6:  152,
+	8:  203,
+	12: 300,
+	24: 600,
+}
+
+// Option letters ~HI reports
+var hostIdentificationOptions = map[rune]string{
+	'C': "cutter",
+	'P': "peeler",
+}
+
+// printerInfo is what a printer says about itself
+type printerInfo struct {
+	Model    string            `json:"model"`
+	Firmware string            `json:"firmware"`
+	DPI      int               `json:"dpi"`
+	Memory   string            `json:"memory"`
+	Options  []string          `json:"options"`
+	Settings map[string]string `json:"settings"`
+	Queried  string            `json:"queried"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type printerInfoCache struct {
+	lock sync.Mutex
+	info *printerInfo
+}
+
+// Only let one conversation with a printer happen at a time, so a status
+// or info query doesn't open a second connection in the middle of a job
+type serializedTransport struct {
+	lock      sync.Mutex
+	transport PrinterTransport
+}
+
+func (t *serializedTransport) Send(zpl string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.transport.Send(zpl)
+}
+
+// Send without waiting for the conversation in progress, for control
+// commands like ~JA that are meant to interrupt it
+func (t *serializedTransport) Interrupt(command string) error {
+	return t.transport.Send(command)
+}
+
+func (t *serializedTransport) Query(command string, complete func([]byte) bool) ([]byte, error) {
+	querier, ok := t.transport.(PrinterQuerier)
+
+	if !ok {
+		return nil, errQueryUnsupported
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return querier.Query(command, complete)
+}
+
+func hostIdentificationComplete(response []byte) bool {
+	return len(framedStrings(response)) >= 1
+}
+
+func parseHostIdentification(response []byte, info *printerInfo) error {
+	frames := framedStrings(response)
+
+	if len(frames) < 1 {
+		return fmt.Errorf("Host identification response is incomplete: %q", string(response))
+	}
+
+	fields := strings.Split(frames[0], ",")
+
+	if len(fields) < 4 {
+		return fmt.Errorf("Host identification response is malformed: %q", string(response))
+	}
+
+	info.Model = strings.TrimSpace(fields[0])
+	info.Firmware = strings.TrimSpace(fields[1])
+	info.Memory = strings.TrimSpace(fields[3])
+
+	dotsPerMM, _ := strconv.Atoi(strings.TrimSpace(fields[2]))
+	info.DPI = dotsPerMMToDPI[dotsPerMM]
+
+	if len(fields) > 4 {
+		for _, letter := range strings.TrimSpace(fields[4]) {
+			if option, ok := hostIdentificationOptions[letter]; ok {
+				info.Options = append(info.Options, option)
+			} else {
+				info.Options = append(info.Options, string(letter))
+			}
+		}
+	}
+
+	return nil
+}
+
+// SGD answers come back wrapped in double quotes
+func sgdComplete(response []byte) bool {
+	return bytes.Count(response, []byte{'"'}) >= 2
+}
+
+func getSGDVar(querier PrinterQuerier, name string) (string, error) {
+	response, err := querier.Query(fmt.Sprintf("! U1 getvar \"%v\"\r\n", name), sgdComplete)
+
+	if err != nil && !sgdComplete(response) {
+		return "", err
+	}
+
+	start := bytes.IndexByte(response, '"')
+	end := bytes.LastIndexByte(response, '"')
+
+	if start == -1 || end <= start {
+		return "", fmt.Errorf("Malformed response to getvar %v: %q", name, string(response))
+	}
+
+	return string(response[start+1 : end]), nil
+}
+
+func queryPrinterInfo(printer PrinterTransport) *printerInfo {
+	info := &printerInfo{
+		Options:  make([]string, 0),
+		Settings: make(map[string]string),
+		Queried:  time.Now().Format(time.RFC3339),
+	}
+
+	querier, ok := printer.(PrinterQuerier)
+
+	if !ok {
+		info.Error = errQueryUnsupported.Error()
+		return info
+	}
+
+	response, err := querier.Query(hostIdentificationCommand, hostIdentificationComplete)
+
+	if err == nil || hostIdentificationComplete(response) {
+		err = parseHostIdentification(response, info)
+	}
+
+	// No point asking anything else of a printer that can't be reached;
+	// each getvar would wait out its own timeout
+	if err == errQueryUnsupported || isConnectError(err) {
+		info.Error = err.Error()
+		return info
+	} else if err != nil {
+		info.Error = err.Error()
+	}
+
+	// Not every printer speaks SGD; whatever it does answer is a bonus
+	for _, name := range append(defaultPrinterInfoVars, Config.PrinterInfoVars...) {
+		value, err := getSGDVar(querier, name)
+
+		if isConnectError(err) {
+			break
+		} else if err == nil && value != "?" {
+			info.Settings[name] = value
+		}
+	}
+
+	if productName := info.Settings["device.product_name"]; productName != "" {
+		info.Model = productName
+	}
+
+	if firmware := info.Settings["appl.name"]; firmware != "" {
+		info.Firmware = firmware
+	}
+
+	if dpi, err := strconv.Atoi(info.Settings["head.resolution.in_dpi"]); err == nil && dpi > 0 {
+		info.DPI = dpi
+	}
+
+	if info.Model != "" {
+		info.Error = ""
+	}
+
+	return info
+}
+
+func (worker *printerWorker) refreshInfo() *printerInfo {
+	info := queryPrinterInfo(worker.transport)
+
+	worker.info.lock.Lock()
+	defer worker.info.lock.Unlock()
+
+	worker.info.info = info
+
+	return info
+}
+
+// Last known info about the printer; nil if it's never been asked
+func (worker *printerWorker) cachedInfo() *printerInfo {
+	worker.info.lock.Lock()
+	defer worker.info.lock.Unlock()
+
+	return worker.info.info
+}
+
+func getPrinterInfo(workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		worker, ok := workers[c.Param("name")]
+
+		if !ok {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Printer not found"})
+		}
+
+		info := worker.cachedInfo()
+
+		if info == nil || c.QueryParam("refresh") != "" {
+			info = worker.refreshInfo()
+		}
+
+		return c.JSON(http.StatusOK, info)
+	}
+}
@@ -0,0 +1,94 @@
+package zplorama
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHostIdentification(t *testing.T) {
+	cases := []struct {
+		response string
+		want     printerInfo
+	}{
+		{"\x02ZT410-203dpi,V75.20.01Z,8,8192KB\x03\r\n", printerInfo{Model: "ZT410-203dpi", Firmware: "V75.20.01Z", DPI: 203, Memory: "8192KB"}},
+		{"\x02ZT410-300dpi,V75.20.01Z,12,8192KB,C\x03\r\n", printerInfo{Model: "ZT410-300dpi", Firmware: "V75.20.01Z", DPI: 300, Memory: "8192KB", Options: []string{"cutter"}}},
+		{"\x02 ZD620 , V84.20.18Z ,24, 512KB ,PCX\x03", printerInfo{Model: "ZD620", Firmware: "V84.20.18Z", DPI: 600, Memory: "512KB", Options: []string{"peeler", "cutter", "X"}}},
+		{"junk\x02GK420d,V61.17.17Z,6,1024KB\x03", printerInfo{Model: "GK420d", Firmware: "V61.17.17Z", DPI: 152, Memory: "1024KB"}},
+		// Unknown resolutions are left for head.resolution.in_dpi to fill in
+		{"\x02QLn320,V68.19.15Z,7,4096KB\x03", printerInfo{Model: "QLn320", Firmware: "V68.19.15Z", Memory: "4096KB"}},
+	}
+
+	for _, test := range cases {
+		var got printerInfo
+
+		if err := parseHostIdentification([]byte(test.response), &got); err != nil {
+			t.Errorf("%q: %v", test.response, err)
+			continue
+		}
+
+		if got.Model != test.want.Model || got.Firmware != test.want.Firmware || got.DPI != test.want.DPI || got.Memory != test.want.Memory {
+			t.Errorf("%q: got %+v, want %+v", test.response, got, test.want)
+		}
+
+		if strings.Join(got.Options, ",") != strings.Join(test.want.Options, ",") {
+			t.Errorf("%q: got options %q, want %q", test.response, got.Options, test.want.Options)
+		}
+	}
+}
+
+func TestParseHostIdentificationMalformed(t *testing.T) {
+	cases := []string{
+		"",
+		"ZT410-203dpi,V75.20.01Z,8,8192KB\r\n",
+		"\x02ZT410-203dpi,V75.20.01Z,8",
+		"\x02ZT410-203dpi,V75.20.01Z\x03",
+	}
+
+	for _, response := range cases {
+		var info printerInfo
+
+		if err := parseHostIdentification([]byte(response), &info); err == nil {
+			t.Errorf("%q: got %+v, want an error", response, info)
+		}
+	}
+}
+
+// Answers every query with the same response
+type cannedQuerier struct {
+	response string
+}
+
+func (querier cannedQuerier) Query(command string, complete func([]byte) bool) ([]byte, error) {
+	return []byte(querier.response), nil
+}
+
+func TestGetSGDVar(t *testing.T) {
+	cases := []struct {
+		response string
+		want     string
+		wantErr  bool
+	}{
+		{`"ZT410"`, "ZT410", false},
+		{`"ZT410 - 203dpi"` + "\r\n", "ZT410 - 203dpi", false},
+		{`""`, "", false},
+		{`"?"`, "?", false},
+		{`"unterminated`, "", true},
+		{"", "", true},
+	}
+
+	for _, test := range cases {
+		got, err := getSGDVar(cannedQuerier{test.response}, "device.product_name")
+
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("%q: got %q (error %v), want %q", test.response, got, err, test.want)
+		}
+	}
+}
+
+func TestQueryPrinterInfoUnreachable(t *testing.T) {
+	info := queryPrinterInfo(&rawPrinterTransport{address: "127.0.0.1:1"})
+
+	if info.Error == "" || info.Model != "" || len(info.Settings) != 0 {
+		t.Errorf("got %+v, want a connection error", info)
+	}
+}
@@ -0,0 +1,297 @@
+package zplorama
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/labstack/echo"
+)
+
+const defaultPrinterName = "default"
+const defaultDPI = 203
+
+// A printer and the goroutine feeding it jobs from the queue
+type printerWorker struct {
+	name      string
+	config    printerConfig
+	transport *serializedTransport
+	// Set for printers that draw their labels rather than print them
+	renderer *renderPrinterTransport
+	// In order; the first one is the one pictures get checked with
+	cameras []*printerCamera
+	wake    chan struct{}
+	tracker workerStateTracker
+	info    printerInfoCache
+	cancels jobCancellations
+}
+
+// One of the cameras watching a printer
+type printerCamera struct {
+	name   string
+	camera *serializedCamera
+	crop   *labelCrop
+	burst  burstSettings
+	live   liveFeed
+	// Hands back the label the printer drew rather than a photograph of it
+	rendered bool
+}
+
+type printerListing struct {
+	Name        string      `json:"name"`
+	Transport   string      `json:"transport"`
+	Address     string      `json:"address"`
+	DPI         int         `json:"dpi"`
+	Media       string      `json:"media"`
+	MediaWidth  float64     `json:"media_width"`
+	MediaLength float64     `json:"media_length"`
+	Camera      string      `json:"camera"`
+	Cameras     []string    `json:"cameras"`
+	Calibrated  string      `json:"calibrated"`
+	Queued      int         `json:"queued"`
+	Default     bool        `json:"default"`
+	Worker      workerState `json:"worker"`
+}
+
+// Fall back on the single print_* printer for configs that don't list any printers
+func configuredPrinters(printerDialAddress string) map[string]printerConfig {
+	if len(Config.Printers) > 0 {
+		return Config.Printers
+	}
+
+	return map[string]printerConfig{
+		defaultPrinterName: {
+			Transport: Config.PrintTransport,
+			Address:   printerDialAddress,
+			LPDQueue:  Config.PrintLPDQueue,
+			BaudRate:  Config.PrintBaudRate,
+		},
+	}
+}
+
+func newPrinterWorker(name string, config printerConfig) (*printerWorker, error) {
+	var transport PrinterTransport
+	var renderer *renderPrinterTransport
+	var err error
+
+	if config.Transport == renderTransport {
+		renderer = &renderPrinterTransport{dpi: config.dpi()}
+		transport = renderer
+	} else {
+		transport, err = newPrinterTransport(config.Transport, config.Address, config.LPDQueue, config.BaudRate)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Printer %v: %v", name, err)
+	}
+
+	var cameras []*printerCamera
+	seen := make(map[string]bool)
+
+	for _, cameraName := range config.cameraNames() {
+		if seen[cameraName] {
+			return nil, fmt.Errorf("Printer %v: camera %v is listed twice", name, cameraName)
+		}
+		seen[cameraName] = true
+
+		settings, err := cameraConfigNamed(cameraName)
+
+		// A printer that only draws labels and isn't given a camera gets one that hands the drawings back
+		if renderer != nil && config.Camera == "" && len(config.Cameras) == 0 {
+			settings, err = cameraConfig{Kind: renderCamera}, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Printer %v: %v", name, err)
+		}
+
+		camera, err := newPrinterCamera(cameraName, settings, renderer)
+
+		if err != nil {
+			return nil, fmt.Errorf("Printer %v: %v", name, err)
+		}
+
+		cameras = append(cameras, camera)
+	}
+
+	media, err := mediaProfileNamed(config.Media)
+
+	if err == nil {
+		err = media.validate()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Printer %v: %v", name, err)
+	}
+
+	return &printerWorker{
+		name:      name,
+		config:    config,
+		transport: &serializedTransport{transport: transport},
+		renderer:  renderer,
+		cameras:   cameras,
+		wake:      make(chan struct{}, 1),
+	}, nil
+}
+
+func newPrinterCamera(name string, settings cameraConfig, renderer *renderPrinterTransport) (*printerCamera, error) {
+	camera, err := newCamera(settings)
+
+	if err != nil {
+		return nil, fmt.Errorf("camera %v: %v", name, err)
+	}
+
+	rendered, isRendered := camera.(*renderedLabelCamera)
+
+	if isRendered && renderer == nil {
+		return nil, fmt.Errorf("camera %v: render cameras only work with the render transport", name)
+	} else if isRendered {
+		rendered.printer = renderer
+	}
+
+	crop, err := newLabelCrop(settings)
+
+	if err != nil {
+		return nil, fmt.Errorf("camera %v: %v", name, err)
+	}
+
+	return &printerCamera{
+		name:     name,
+		camera:   &serializedCamera{Camera: camera},
+		crop:     crop,
+		burst:    newBurstSettings(settings),
+		rendered: isRendered,
+	}, nil
+}
+
+// The printer's cameras in order: cameras if given, otherwise just camera
+func (config *printerConfig) cameraNames() []string {
+	names := config.Cameras
+	if len(names) == 0 {
+		names = []string{config.Camera}
+	}
+
+	named := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == "" {
+			name = defaultCameraName
+		}
+
+		named = append(named, name)
+	}
+
+	return named
+}
+
+// The camera pictures get checked with
+func (worker *printerWorker) primaryCamera() *printerCamera {
+	return worker.cameras[0]
+}
+
+func (worker *printerWorker) cameraNames() []string {
+	names := make([]string, 0, len(worker.cameras))
+
+	for _, camera := range worker.cameras {
+		names = append(names, camera.name)
+	}
+
+	return names
+}
+
+// One of the printer's cameras by name, "" for the primary one
+func (worker *printerWorker) cameraNamed(name string) (*printerCamera, bool) {
+	if name == "" {
+		return worker.primaryCamera(), true
+	}
+
+	for _, camera := range worker.cameras {
+		if camera.name == name {
+			return camera, true
+		}
+	}
+
+	return nil, false
+}
+
+func (config *printerConfig) dpi() int {
+	if config.DPI > 0 {
+		return config.DPI
+	}
+
+	return defaultDPI
+}
+
+// The media profile a job prints on: its own if it asked for one, otherwise the printer's
+func (worker *printerWorker) mediaName(requested string) string {
+	if requested != "" {
+		return requested
+	} else if worker.config.Media != "" {
+		return worker.config.Media
+	}
+
+	return defaultMediaName
+}
+
+func sortedPrinterNames(workers map[string]*printerWorker) []string {
+	names := make([]string, 0, len(workers))
+
+	for name := range workers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Which printer gets jobs that don't ask for one
+func defaultPrinter(workers map[string]*printerWorker) string {
+	if _, ok := workers[Config.DefaultPrinter]; ok {
+		return Config.DefaultPrinter
+	} else if _, ok := workers[defaultPrinterName]; ok {
+		return defaultPrinterName
+	}
+
+	names := sortedPrinterNames(workers)
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	return names[0]
+}
+
+func listPrinters(database *bolt.DB, workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		printers := make([]printerListing, 0, len(workers))
+		defaultName := defaultPrinter(workers)
+
+		for _, name := range sortedPrinterNames(workers) {
+			worker := workers[name]
+			state := worker.currentState()
+			state.Queued = countQueuedJobs(database, name)
+			media, _ := mediaProfileNamed(worker.mediaName(""))
+			calibration := calibrationRecord{Printer: name, Camera: worker.primaryCamera().name}
+			GetRecord(database, &calibration)
+
+			printers = append(printers, printerListing{
+				Name:        name,
+				Transport:   worker.config.Transport,
+				Address:     worker.config.Address,
+				DPI:         media.dpi(worker.config.DPI),
+				Media:       worker.mediaName(""),
+				MediaWidth:  media.Width,
+				MediaLength: media.Length,
+				Camera:      worker.primaryCamera().name,
+				Cameras:     worker.cameraNames(),
+				Calibrated:  calibration.Created,
+				Queued:      state.Queued,
+				Default:     name == defaultName,
+				Worker:      state,
+			})
+		}
+
+		return c.JSON(http.StatusOK, printers)
+	}
+}
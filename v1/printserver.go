@@ -1,34 +1,21 @@
 package zplorama
 
 import (
-	"bytes"
-	"encoding/base64"
-	"errors"
 	"fmt"
-	"net"
+	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 	"github.com/hashicorp/mdns"
+	"github.com/jasonbot/zpl-o-rama/v1/zpl"
 	"github.com/labstack/echo"
 )
 
-const resetCommand string = `^XA
-^FWN
-^LL1218
-^PW812
-^PON
-^LH0,0
-^LT0
-^CI28
-^XZ
-`
-
 func startJob(db *bolt.DB, jobID string) {
 	jobRecord := jobTimestamp{
 		Timestamp: time.Now().Format(time.RFC3339),
@@ -37,6 +24,32 @@ func startJob(db *bolt.DB, jobID string) {
 	PutRecord(db, &jobRecord)
 }
 
+// Held while a job's record gets read and written back, or finished off,
+// so a cancel can't land on top of a job the worker has just finished
+var jobRecordLock sync.Mutex
+
+// Mark a job cancelled unless it has already finished, and return it as it
+// now stands
+func markCancelled(db *bolt.DB, jobID string, message string, done bool) (*printJobStatus, error) {
+	jobRecordLock.Lock()
+	defer jobRecordLock.Unlock()
+
+	status := &printJobStatus{Jobid: jobID}
+
+	if err := GetRecord(db, status); err != nil {
+		return nil, err
+	}
+
+	if !status.Done {
+		status.Status = cancelled
+		status.Message = message
+		status.Done = done
+		updateJob(db, status)
+	}
+
+	return status, nil
+}
+
 func updateJob(db *bolt.DB, job *printJobStatus) {
 	if job.Log == nil {
 		job.Log = make([]string, 0)
@@ -48,100 +61,385 @@ func updateJob(db *bolt.DB, job *printJobStatus) {
 
 	job.Updated = time.Now().Format(time.RFC3339)
 	PutRecord(db, job)
+
+	if job.Parent != "" {
+		updateParentJob(db, job)
+	}
 }
 
-func sendZPL(dial, zpl string) error {
-	conn, err := net.DialTimeout("tcp", dial, 1*time.Second)
+// Ask the printer if it's in a state to print; if it isn't, hold the job for
+// up to Config.StatusHoldTime waiting for someone to fix it.
+func (worker *printerWorker) waitForPrinterReady(db *bolt.DB, status *printJobStatus) error {
+	if Config.SkipStatusCheck || worker.renderer != nil {
+		return nil
+	}
+
+	giveUp := time.Now().Add(parseDurationOr(Config.StatusHoldTime, 0))
+
+	for {
+		if worker.cancelRequested(status.Jobid) {
+			return errJobCancelled
+		}
+
+		hostStatus, err := queryHostStatus(worker.transport)
+
+		if err == errQueryUnsupported {
+			status.Message = "Printer transport does not report status, not checking if printer is ready"
+			updateJob(db, status)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Could not read printer status: %v", err)
+		}
+
+		if hostStatus.Ready() {
+			return nil
+		}
+
+		notReady := notReadyError(hostStatus)
+
+		if time.Now().After(giveUp) {
+			return notReady
+		}
+
+		status.Message = fmt.Sprintf("Holding job: %v", notReady)
+		updateJob(db, status)
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// See if anything went wrong on the printer while printing the job
+func checkPrinterAfterJob(db *bolt.DB, status *printJobStatus, printer PrinterTransport) error {
+	if Config.SkipStatusCheck {
+		return nil
+	}
+
+	hostStatus, err := queryHostStatus(printer)
+
+	if err == errQueryUnsupported {
+		return nil
+	} else if err != nil {
+		status.Message = fmt.Sprintf("Could not read printer status after printing: %v", err)
+		updateJob(db, status)
+		return nil
+	}
+
+	if !hostStatus.Ready() {
+		return fmt.Errorf("Printer reported a problem after printing: %v", strings.Join(hostStatus.Problems(), ", "))
+	}
+
+	return nil
+}
+
+func parseDurationOr(duration string, fallback time.Duration) time.Duration {
+	td, err := time.ParseDuration(duration)
 
 	if err != nil {
-		return err
+		return fallback
 	}
-	defer conn.Close()
 
-	_, err = conn.Write([]byte(zpl))
-	return err
+	return td
 }
 
-func takePicture() ([]byte, error) {
-	out, err := exec.Command("raspistill", "-t", "3000", "-e", "png", "-o", "-").Output()
+// Poll ~HS until the printer has nothing left in its buffer and no labels left
+// in the batch, waiting no longer than Config.PrintTime. Transports that can't
+// report status just wait out the whole Config.PrintTime.
+func (worker *printerWorker) waitForPrintCompletion(db *bolt.DB, status *printJobStatus) {
+	maxWait := parseDurationOr(Config.PrintTime, 5*time.Second)
+	pollInterval := parseDurationOr(Config.StatusPollInterval, 250*time.Millisecond)
+	settleTime := parseDurationOr(Config.PrintSettleTime, 500*time.Millisecond)
+
+	started := time.Now()
+	giveUp := started.Add(maxWait)
+
+	// Drawing the label is done by the time sending it is
+	if worker.renderer != nil {
+		return
+	}
+
+	if Config.SkipStatusCheck {
+		worker.sleepUnlessCancelled(status.Jobid, maxWait)
+		return
+	}
+
+	// The printer can look idle for a moment before it has parsed what we
+	// sent, so only trust an idle reading after seeing it busy or after a grace period
+	sawBusy := false
+	idleCount := 0
+
+	for time.Now().Before(giveUp) {
+		if worker.cancelRequested(status.Jobid) {
+			return
+		}
+
+		hostStatus, err := queryHostStatus(worker.transport)
+
+		if err == errQueryUnsupported {
+			worker.sleepUnlessCancelled(status.Jobid, time.Until(giveUp))
+			return
+		} else if err != nil {
+			idleCount = 0
+		} else if !hostStatus.Ready() {
+			// No point waiting on a printer that has stopped; the post-job check will report why
+			return
+		} else if hostStatus.FormatsInBuffer > 0 || hostStatus.LabelsRemaining > 0 || hostStatus.PartialFormat {
+			sawBusy = true
+			idleCount = 0
+		} else {
+			idleCount++
+		}
+
+		if idleCount >= 2 && (sawBusy || time.Since(started) > time.Second) {
+			status.Message = fmt.Sprintf("Printer finished after %v", time.Since(started).Round(time.Millisecond))
+			updateJob(db, status)
+
+			time.Sleep(settleTime)
+			return
+		}
 
-	return out, err
+		time.Sleep(pollInterval)
+	}
+
+	status.Message = fmt.Sprintf("Printer did not report completion within %v, taking picture anyway", maxWait)
+	updateJob(db, status)
 }
 
-func handleJobs(jobCache chan *printJobRequest, db *bolt.DB, printerAddress string) error {
-	for jobToDo := range jobCache {
-		startJob(db, jobToDo.jobid)
+// Send to the printer, retrying with backoff while the printer can't be reached
+func (worker *printerWorker) sendWithRetry(db *bolt.DB, status *printJobStatus, zpl string) error {
+	backoff := parseDurationOr(Config.RetryBackoff, 1*time.Second)
+	maxBackoff := parseDurationOr(Config.RetryMaxBackoff, 30*time.Second)
 
-		status := printJobStatus{
-			Jobid:         jobToDo.jobid,
-			Status:        processing,
-			ZPL:           jobToDo.ZPL,
-			ImageB64:      emptyPNG,
-			ImageB64Small: emptyPNG,
-			Created:       time.Now().Format(time.RFC3339),
-			Updated:       time.Now().Format(time.RFC3339),
-			Author:        jobToDo.Author,
-			Message:       "Job started, enqueueing",
-			Log:           make([]string, 0),
-			Done:          false,
+	for attempt := 0; ; attempt++ {
+		if worker.cancelRequested(status.Jobid) {
+			return errJobCancelled
 		}
 
-		updateJob(db, &status)
+		err := worker.transport.Send(zpl)
 
-		var err error
+		if err == nil {
+			worker.setState(workerPrinting, nil)
+			return nil
+		}
 
-		if status.ZPL != "" {
-			err = sendZPL(printerAddress, resetCommand)
-			if err != nil {
-				return err
-			}
+		// Once some of the job made it to the printer, sending it again could print it twice
+		if !isConnectError(err) {
+			return err
+		}
 
-			err = sendZPL(printerAddress, status.ZPL)
+		if attempt >= Config.RetryAttempts {
+			return fmt.Errorf("Giving up on printer after %v attempts: %v", attempt+1, err)
+		}
 
-			if err == nil {
-				td, err := time.ParseDuration(Config.PrintTime)
+		worker.setState(workerRetrying, err)
+		status.Message = fmt.Sprintf("Could not reach printer (%v), retrying in %v", err, backoff)
+		updateJob(db, status)
 
-				if err != nil {
-					td = 5 * time.Second
-					err = nil
-				}
+		time.Sleep(backoff)
 
-				time.Sleep(td)
-			}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
 		}
+	}
+}
 
-		if err != nil {
-			status.Status = failed
-			status.Message = err.Error()
-			status.ImageB64 = sadFace
-			status.ImageB64Small = sadFace
-		} else {
+func (worker *printerWorker) processJob(db *bolt.DB, jobToDo *printJobRequest) {
+	existing := printJobStatus{Jobid: jobToDo.jobid}
+
+	// Cancelled while it was still in the queue
+	if worker.cancelRequested(jobToDo.jobid) || (GetRecord(db, &existing) == nil && existing.Done) {
+		worker.clearCancel(jobToDo.jobid)
+		markCancelled(db, jobToDo.jobid, "Job cancelled before printing", true)
+		worker.finishJob(cancelled, "")
+		return
+	}
+	defer worker.clearCancel(jobToDo.jobid)
+
+	startJob(db, jobToDo.jobid)
+
+	status := printJobStatus{
+		Jobid:      jobToDo.jobid,
+		Printer:    worker.name,
+		Media:      worker.mediaName(jobToDo.Media),
+		Status:     processing,
+		ZPL:        jobToDo.ZPL,
+		Lint:       zpl.Lint(jobToDo.ZPL, worker.lintOptions(jobToDo.Media)),
+		Created:    time.Now().Format(time.RFC3339),
+		Updated:    time.Now().Format(time.RFC3339),
+		Author:     jobToDo.Author,
+		ComparedTo: jobToDo.Baseline,
+		Parent:     existing.Parent,
+		Label:      existing.Label,
+		Message:    "Job started, enqueueing",
+		Log:        make([]string, 0),
+		Done:       false,
+	}
+
+	if info := worker.cachedInfo(); info != nil {
+		status.PrinterModel = info.Model
+		status.PrinterFirmware = info.Firmware
+	}
+
+	updateJob(db, &status)
+
+	var err error
+	var printerProblem error
+	var burst *burstCapture
+	var finalPicture []byte
 
-			imageBytes, err := takePicture()
-			var b64string, b64smallstring string
-			if err == nil {
-				b64string = base64.StdEncoding.EncodeToString(imageBytes)
-				b64smallstring, err = shrinkImage(b64string)
+	if status.ZPL != "" {
+		var media mediaProfile
+		var reset string
+		media, err = mediaProfileNamed(status.Media)
+
+		if err == nil {
+			reset, err = media.resetCommand(worker.config.DPI)
+		}
+
+		if err == nil {
+			err = worker.waitForPrinterReady(db, &status)
+		}
+
+		if err == nil {
+			err = worker.sendWithRetry(db, &status, reset)
+		}
+
+		if err == nil && jobToDo.Burst {
+			burst = worker.primaryCamera().startBurst()
+		}
+
+		if err == nil {
+			err = worker.sendWithRetry(db, &status, status.ZPL)
+		}
+
+		if err == nil {
+			worker.waitForPrintCompletion(db, &status)
+
+			printerProblem = checkPrinterAfterJob(db, &status, worker.transport)
+		}
+	}
+
+	frames := burst.finish()
+
+	// Whatever made it onto a label before the cancel still gets photographed
+	wasCancelled := worker.cancelRequested(status.Jobid)
+	if wasCancelled {
+		err = nil
+	}
+
+	if err != nil {
+		status.Status = failed
+		status.Message = err.Error()
+	} else {
+		worker.setState(workerCapturing, nil)
+
+		var captures []cameraCapture
+		captures, err = worker.captureAll(&status)
+
+		// The first camera's picture is the one that gets checked
+		var imageBytes []byte
+		if len(captures) > 0 && captures[0].err == nil {
+			finalPicture = captures[0].raw
+			imageBytes = captures[0].label
+			status.Rendered = captures[0].rendered
+		}
+
+		// Kept whatever the checks below make of them, so a failed
+		// calibration still shows the picture it was tried on, and a
+		// camera that failed doesn't lose the others' pictures
+		var images []jobImage
+		var imageErr error
+		for _, capture := range captures {
+			var image jobImage
+			image, imageErr = capture.jobImage()
+
+			if imageErr != nil {
+				break
 			}
 
-			if err == nil {
-				status.Status = succeeded
-				status.Message = "Successfully processed request"
-				status.ImageB64 = b64string
-				status.ImageB64Small = b64smallstring
+			images = append(images, image)
+		}
+
+		if imageErr == nil {
+			status.Images = images
+		} else if err == nil {
+			err = imageErr
+		}
+
+		if err == nil && jobToDo.Calibrate {
+			if calibrateErr := worker.calibrate(db, &status, imageBytes); calibrateErr != nil {
+				err = fmt.Errorf("Could not calibrate camera: %v", calibrateErr)
 			} else {
-				status.Message = err.Error()
-				status.Status = failed
-				status.ImageB64 = sadFace
-				status.ImageB64Small = sadFace
+				status.Log = append(status.Log, "Calibrated camera from this label")
 			}
 		}
-		status.Done = true
 
-		updateJob(db, &status)
+		if err == nil && status.ZPL != "" {
+			if measureErr := worker.measureLabel(db, &status, imageBytes); measureErr != nil {
+				status.Log = append(status.Log, fmt.Sprintf("Could not measure label: %v", measureErr))
+			} else if status.Measurement != nil {
+				status.Log = append(status.Log, fmt.Sprintf("Label measured %vx%vmm, expected %vx%vmm",
+					status.Measurement.WidthMM, status.Measurement.LengthMM,
+					status.Measurement.ExpectedWidthMM, status.Measurement.ExpectedLengthMM))
+			}
+
+			status.Barcodes, err = verifyBarcodes(status.ZPL, imageBytes)
+
+			if err != nil {
+				status.Log = append(status.Log, fmt.Sprintf("Could not check barcodes: %v", err))
+				err = nil
+			} else if len(status.Barcodes) > 0 {
+				passed, unverified := 0, 0
+				for _, barcode := range status.Barcodes {
+					if barcode.Status == barcodePassed {
+						passed++
+					} else if barcode.Status == barcodeUnverified {
+						unverified++
+					}
+				}
+
+				summary := fmt.Sprintf("%v of %v barcodes scanned correctly", passed, len(status.Barcodes))
+				if unverified > 0 {
+					summary += fmt.Sprintf(", %v not verified", unverified)
+				}
+
+				status.Log = append(status.Log, summary)
+			}
+		}
+
+		if err == nil && status.ComparedTo != "" {
+			compareWithBaseline(db, &status, imageBytes)
+		}
+
+		if err == nil && wasCancelled {
+			status.Status = cancelled
+			status.Message = "Job cancelled"
+		} else if err == nil && printerProblem != nil {
+			status.Status = failed
+			status.Message = printerProblem.Error()
+		} else if err == nil {
+			status.Status = succeeded
+			status.Message = "Successfully processed request"
+		} else if wasCancelled {
+			status.Status = cancelled
+			status.Message = fmt.Sprintf("Job cancelled, could not take picture: %v", err)
+		} else {
+			status.Message = err.Error()
+			status.Status = failed
+		}
 	}
 
-	return nil
+	if burst != nil {
+		saveBurst(db, &status, burst, frames, finalPicture)
+	}
+
+	status.Done = true
+
+	jobRecordLock.Lock()
+	updateJob(db, &status)
+	jobRecordLock.Unlock()
+
+	worker.finishJob(status.Status, status.Message)
 }
 
 func getJob(database *bolt.DB) func(echo.Context) error {
@@ -159,67 +457,165 @@ func getJob(database *bolt.DB) func(echo.Context) error {
 	}
 }
 
-func printJob(database *bolt.DB, requestor chan *printJobRequest) func(echo.Context) error {
+func cancelJob(database *bolt.DB, workers map[string]*printerWorker) func(echo.Context) error {
 	return func(c echo.Context) error {
-		var err error
+		jobStatus := new(printJobStatus)
+		jobStatus.Jobid = c.Param("id")
 
-		printRequest := new(printJobRequest)
-		c.Bind(&printRequest)
+		err := GetRecord(database, jobStatus)
 
-		jobid := uuid.NewString()
-		printRequest.jobid = jobid
+		if err != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job not found"})
+		}
 
-		response := printJobStatus{
-			Jobid:    jobid,
-			Status:   pending,
-			ZPL:      printRequest.ZPL,
-			ImageB64: emptyPNG,
-			Created:  time.Now().Format(time.RFC3339),
-			Updated:  time.Now().Format(time.RFC3339),
-			Author:   printRequest.Author,
-			Message:  "Job created",
-			Done:     false,
+		if jobStatus.Done {
+			return c.JSON(http.StatusConflict, errJSON{Errmsg: fmt.Sprintf("Job is already %v", jobStatus.Status)})
 		}
 
-		updateJob(database, &response)
+		worker, ok := workers[jobStatus.Printer]
 
-		select {
-		case requestor <- printRequest:
-			break
-		case <-time.After(5 * time.Second):
-			err = errors.New("Failed to queue job in time")
-			response.Status = failed
-			response.ImageB64 = sadFace
-			response.Message = "Timed out waiting to job to worker; is the system overloaded?"
-			response.Done = true
-			updateJob(database, &response)
+		if !ok {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job's printer not found"})
+		}
+
+		if len(jobStatus.Subjobs) > 0 {
+			err = worker.cancelSubjobs(database, jobStatus)
+		} else {
+			err = worker.cancel(database, jobStatus)
 		}
 
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, struct {
-				Errmsg string `json:"error"`
-			}{Errmsg: err.Error()})
+			return c.JSON(http.StatusBadGateway, errJSON{Errmsg: err.Error()})
 		}
 
-		return c.Redirect(http.StatusFound, fmt.Sprintf("/job/%s", jobid))
+		return c.JSON(http.StatusOK, jobStatus)
 	}
 }
 
-func shrinkImage(imageB64 string) (string, error) {
-	data, _ := base64.StdEncoding.DecodeString(imageB64)
+// Stop a job: the printer gets told to drop what it's printing if it's the
+// job being worked on, otherwise it comes out of the queue. jobStatus is
+// updated to how the job stands afterwards.
+func (worker *printerWorker) cancel(database *bolt.DB, jobStatus *printJobStatus) error {
+	var current *printJobStatus
+	var err error
+
+	if worker.requestCancel(jobStatus.Jobid) {
+		// The worker notices the cancel request, takes its picture and finishes the job off
+		if err := worker.transport.Interrupt(cancelAllCommand); err != nil {
+			return fmt.Errorf("Could not cancel on printer: %v", err)
+		}
+
+		current, err = markCancelled(database, jobStatus.Jobid, "Job cancelled, finishing up", false)
+	} else {
+		removeQueuedJob(database, jobStatus.Jobid)
+
+		current, err = markCancelled(database, jobStatus.Jobid, "Job cancelled before printing", true)
+		worker.forgetCancel(jobStatus.Jobid)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*jobStatus = *current
+
+	return nil
+}
+
+// Record a new job and queue it up for the worker, returning its id
+func submitJob(database *bolt.DB, worker *printerWorker, printRequest *printJobRequest) (string, error) {
+	if printRequest.jobid == "" {
+		printRequest.jobid = uuid.NewString()
+	}
+	jobid := printRequest.jobid
+
+	response := printJobStatus{
+		Jobid:      jobid,
+		Printer:    printRequest.Printer,
+		Media:      worker.mediaName(printRequest.Media),
+		Status:     pending,
+		ZPL:        printRequest.ZPL,
+		Lint:       zpl.Lint(printRequest.ZPL, worker.lintOptions(printRequest.Media)),
+		Created:    time.Now().Format(time.RFC3339),
+		Updated:    time.Now().Format(time.RFC3339),
+		Author:     printRequest.Author,
+		ComparedTo: printRequest.Baseline,
+		Parent:     printRequest.parent,
+		Label:      printRequest.label,
+		Message:    "Job created",
+		Done:       false,
+	}
+
+	updateJob(database, &response)
 
-	image, err := imaging.Decode(bytes.NewBuffer(data))
+	err := enqueueJob(database, printRequest)
 
 	if err != nil {
-		return "", err
+		err = fmt.Errorf("Failed to queue job: %v", err)
+		response.Status = failed
+		response.Message = err.Error()
+		response.Done = true
+		updateJob(database, &response)
+
+		return jobid, err
 	}
 
-	resizedImage := imaging.Resize(image, 800, 0, imaging.Box)
+	worker.notify()
+
+	return jobid, nil
+}
+
+func printJob(database *bolt.DB, workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		printRequest := new(printJobRequest)
+		c.Bind(&printRequest)
+
+		if printRequest.Printer == "" {
+			printRequest.Printer = defaultPrinter(workers)
+		}
+
+		worker, ok := workers[printRequest.Printer]
 
-	var smallImage bytes.Buffer
-	imaging.Encode(&smallImage, resizedImage, imaging.PNG)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: fmt.Sprintf("Unknown printer %v", printRequest.Printer)})
+		}
+
+		// Only the printer's own media gets checked when it starts up
+		if media, err := mediaProfileNamed(worker.mediaName(printRequest.Media)); err != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+		} else if err := media.validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: fmt.Sprintf("Media %v: %v", worker.mediaName(printRequest.Media), err)})
+		}
+
+		if printRequest.Baseline != "" && GetRecord(database, &baselineRecord{Jobid: printRequest.Baseline}) != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: fmt.Sprintf("Unknown baseline %v", printRequest.Baseline)})
+		}
+
+		// Held to lint_reject here too, so posting straight to the print
+		// server doesn't get around what the frontend turns away
+		diagnostics := zpl.Lint(printRequest.ZPL, worker.lintOptions(printRequest.Media))
+		reject, err := lintRejects(diagnostics)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		} else if reject {
+			return c.JSON(http.StatusBadRequest, lintRejection{Errmsg: "Not printing ZPL with problems", Diagnostics: diagnostics})
+		}
+
+		var jobid string
+
+		if printRequest.Split {
+			jobid, err = submitSplitJob(database, worker, printRequest)
+		} else {
+			jobid, err = submitJob(database, worker, printRequest)
+		}
 
-	return base64.StdEncoding.EncodeToString(smallImage.Bytes()), nil
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+		}
+
+		return c.Redirect(http.StatusFound, fmt.Sprintf("/job/%s", jobid))
+	}
 }
 
 // RunPrintServer executes the HTTP server
@@ -227,8 +623,42 @@ func RunPrintServer(serviceHost string, port int, printerDialAddress string) {
 	database := createDB(Config.BackendDatabase)
 	defer database.Close()
 
-	requestChain := make(chan *printJobRequest, 20)
-	go handleJobs(requestChain, database, printerDialAddress)
+	workers := make(map[string]*printerWorker)
+
+	for name, config := range configuredPrinters(printerDialAddress) {
+		worker, err := newPrinterWorker(name, config)
+
+		if err != nil {
+			panic(err)
+		}
+
+		workers[name] = worker
+	}
+
+	err := migrateJobImages(database, workers)
+
+	if err == nil {
+		err = recoverQueue(database, workers)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, worker := range workers {
+		for _, camera := range worker.cameras {
+			if warm, ok := camera.camera.Camera.(WarmCamera); ok {
+				err := warm.Start()
+
+				if err != nil {
+					log.Printf("Could not start camera %v for printer %v: %v", camera.name, worker.name, err)
+				}
+			}
+		}
+
+		go worker.refreshInfo()
+		go worker.supervise(database)
+	}
 
 	// Announce on network it exists
 	host, _ := os.Hostname()
@@ -242,6 +672,22 @@ func RunPrintServer(serviceHost string, port int, printerDialAddress string) {
 	e.Debug = true
 
 	e.GET("/job/:id", getJob(database))
-	e.POST("/print", printJob(database, requestChain))
+	e.POST("/print", printJob(database, workers))
+	e.DELETE("/job/:id", cancelJob(database, workers))
+	e.POST("/job/:id/baseline", markBaseline(database))
+	e.DELETE("/job/:id/baseline", unmarkBaseline(database))
+	e.GET("/job/:id/diff/:baseline", getJobDiff(database))
+	e.GET("/job/:id/timelapse.gif", getJobTimelapse(database))
+	e.GET("/job/:id/frames/:frame", getJobFrame(database))
+	e.GET("/job/:id/rendered.png", getJobRendering(database, workers))
+	e.GET("/baselines", getBaselines(database))
+	e.GET("/printers", listPrinters(database, workers))
+	e.GET("/printers/:name/worker", getWorkerState(database, workers))
+	e.GET("/printers/:name/info", getPrinterInfo(workers))
+	e.GET("/printers/:name/live", getLiveView(workers))
+	e.POST("/printers/:name/calibrate", calibratePrinter(database, workers))
+	e.GET("/media", listMedia)
+	e.POST("/lint", lintZPL(workers))
+	e.POST("/render", renderZPL(workers))
 	e.Logger.Fatal(e.Start(fmt.Sprintf(":%v", port)))
 }
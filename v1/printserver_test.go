@@ -0,0 +1,204 @@
+package zplorama
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const testLabel = "^XA^FO50,50^A0N,50,50^FDHello from the test^FS^XZ"
+
+// A fake printer on a port of its own, saving labels to a temporary directory
+func startFakePrinter(t *testing.T, labelTime time.Duration) (*fakePrinter, string) {
+	printer := newFakePrinter(t.TempDir(), labelTime, false, false)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go printer.printLabels()
+	go printer.serve(listener)
+
+	return printer, listener.Addr().String()
+}
+
+// A worker printing to address over raw TCP, photographing with a fake camera
+func startTestWorker(t *testing.T, address string) (*printerWorker, *bolt.DB) {
+	saved := Config
+	t.Cleanup(func() { Config = saved })
+
+	Config.Cameras = map[string]cameraConfig{"fake": {Kind: fakeCamera}}
+	Config.SkipStatusCheck = false
+	Config.StatusHoldTime = "0s"
+	Config.StatusPollInterval = "20ms"
+	Config.PrintSettleTime = "0s"
+	Config.PrintTime = "5s"
+	Config.RetryAttempts = 0
+	Config.RetryBackoff = "10ms"
+
+	worker, err := newPrinterWorker("test", printerConfig{Transport: rawTransport, Address: address, Camera: "fake"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := createTestDB(t)
+	stopped := make(chan struct{})
+
+	go func() {
+		worker.supervise(db)
+		close(stopped)
+	}()
+
+	// Cleanups run last to first, so the worker is done with the database before it closes
+	t.Cleanup(func() {
+		close(worker.wake)
+		<-stopped
+	})
+
+	return worker, db
+}
+
+func waitForJob(t *testing.T, db *bolt.DB, jobID string) printJobStatus {
+	giveUp := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(giveUp) {
+		status := printJobStatus{Jobid: jobID}
+
+		if GetRecord(db, &status) == nil && status.Done {
+			return status
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("Job %v did not finish", jobID)
+	return printJobStatus{}
+}
+
+func savedLabels(t *testing.T, printer *fakePrinter) []string {
+	files, err := filepath.Glob(filepath.Join(printer.labelDir, "*.zpl"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var labels []string
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		labels = append(labels, string(contents))
+	}
+
+	return labels
+}
+
+func TestPrintJob(t *testing.T) {
+	printer, address := startFakePrinter(t, 50*time.Millisecond)
+	worker, db := startTestWorker(t, address)
+
+	jobID, err := submitJob(db, worker, &printJobRequest{ZPL: testLabel, Printer: "test"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForJob(t, db, jobID)
+
+	if status.Status != succeeded {
+		t.Errorf("got %v (%v), want %v", status.Status, status.Message, succeeded)
+	}
+
+	if len(status.Images) != 1 || status.Images[0].Error != "" {
+		t.Errorf("got images %+v, want one picture", status.Images)
+	}
+
+	// The media reset has no fields, so only the job itself makes it onto a label
+	labels := savedLabels(t, printer)
+
+	if len(labels) != 1 || labels[0] != testLabel {
+		t.Errorf("got labels %q, want %q", labels, []string{testLabel})
+	}
+}
+
+func TestPrintJobHeldForPaperOut(t *testing.T) {
+	printer, address := startFakePrinter(t, 50*time.Millisecond)
+	worker, db := startTestWorker(t, address)
+
+	printer.lock.Lock()
+	printer.problems.PaperOut = true
+	printer.lock.Unlock()
+
+	jobID, err := submitJob(db, worker, &printJobRequest{ZPL: testLabel, Printer: "test"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForJob(t, db, jobID)
+	want := "Printer not ready: paper out"
+
+	if status.Status != failed || status.Message != want {
+		t.Errorf("got %v (%v), want %v (%v)", status.Status, status.Message, failed, want)
+	}
+
+	if state := printer.state(); state.FormatsInBuffer != 0 || state.LabelsPrinted != 0 {
+		t.Errorf("got %+v, want nothing sent to the printer", state)
+	}
+}
+
+func TestCancelPrintingJob(t *testing.T) {
+	printer, address := startFakePrinter(t, 10*time.Second)
+	worker, db := startTestWorker(t, address)
+
+	jobID, err := submitJob(db, worker, &printJobRequest{ZPL: testLabel, Printer: "test"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	giveUp := time.Now().Add(5 * time.Second)
+	for printer.state().FormatsInBuffer == 0 {
+		if time.Now().After(giveUp) {
+			t.Fatal("Label never reached the printer")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := printJobStatus{Jobid: jobID}
+
+	if err := GetRecord(db, &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := worker.cancel(db, &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Status != cancelled || status.Done {
+		t.Errorf("got %v (done %v) right after cancelling, want %v while the picture gets taken", status.Status, status.Done, cancelled)
+	}
+
+	status = waitForJob(t, db, jobID)
+
+	if status.Status != cancelled {
+		t.Errorf("got %v (%v), want %v", status.Status, status.Message, cancelled)
+	}
+
+	// ~JA empties the printer's buffer before the label is done
+	if state := printer.state(); state.FormatsInBuffer != 0 || state.LabelsPrinted != 0 {
+		t.Errorf("got %+v, want the label cancelled on the printer", state)
+	}
+}
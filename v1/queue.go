@@ -0,0 +1,194 @@
+package zplorama
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// queuedJob is a job waiting on (or being worked on by) a printer. It stays in
+// the queue until the printer is done with it, so a restart can pick it back up.
+type queuedJob struct {
+	Sequence uint64          `json:"sequence"`
+	Jobid    string          `json:"jobid"`
+	Request  printJobRequest `json:"request"`
+}
+
+// Make this struct boltable
+func (*queuedJob) Table() string {
+	return queueTable
+}
+
+// Zero-padded so the bucket's byte ordering is queue order
+func (job *queuedJob) Key() string {
+	return fmt.Sprintf("%020d", job.Sequence)
+}
+
+func (job *queuedJob) request() *printJobRequest {
+	request := job.Request
+	request.jobid = job.Jobid
+
+	return &request
+}
+
+func enqueueJob(database *bolt.DB, request *printJobRequest) error {
+	return database.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(queueTable))
+
+		sequence, err := bucket.NextSequence()
+
+		if err != nil {
+			return err
+		}
+
+		job := queuedJob{
+			Sequence: sequence,
+			Jobid:    request.jobid,
+			Request:  *request,
+		}
+
+		recordBytes, err := json5.Marshal(&job)
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(job.Key()), recordBytes)
+	})
+}
+
+// Everything in the queue, oldest first
+func queuedJobs(database *bolt.DB) ([]queuedJob, error) {
+	jobs := make([]queuedJob, 0)
+
+	err := database.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(queueTable)).ForEach(func(key, value []byte) error {
+			var job queuedJob
+
+			if err := json5.Unmarshal(value, &job); err != nil {
+				return err
+			}
+
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+
+	return jobs, err
+}
+
+// Oldest job waiting on a printer, or nil if there's nothing to do
+func nextQueuedJob(database *bolt.DB, printer string) (*queuedJob, error) {
+	jobs, err := queuedJobs(database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if job.Request.Printer == printer {
+			return &job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func countQueuedJobs(database *bolt.DB, printer string) int {
+	jobs, _ := queuedJobs(database)
+	count := 0
+
+	for _, job := range jobs {
+		if job.Request.Printer == printer {
+			count++
+		}
+	}
+
+	return count
+}
+
+func removeQueuedJob(database *bolt.DB, jobID string) error {
+	jobs, err := queuedJobs(database)
+
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.Jobid == jobID {
+			return DeleteRecord(database, &job)
+		}
+	}
+
+	return nil
+}
+
+// After a restart, put queued jobs back in line and clean up ones that were
+// cut off mid-print (or that predate the queue being persisted at all)
+func recoverQueue(database *bolt.DB, workers map[string]*printerWorker) error {
+	jobs, err := queuedJobs(database)
+
+	if err != nil {
+		return err
+	}
+
+	inQueue := make(map[string]bool)
+
+	for _, job := range jobs {
+		status := printJobStatus{Jobid: job.Jobid}
+		err := GetRecord(database, &status)
+
+		if err != nil || status.Done {
+			DeleteRecord(database, &job)
+			continue
+		}
+
+		if _, ok := workers[job.Request.Printer]; !ok {
+			failJob(database, job.Jobid, fmt.Sprintf("Printer %v is no longer configured", job.Request.Printer))
+			DeleteRecord(database, &job)
+			continue
+		}
+
+		if status.Status == processing {
+			if !Config.RetryInterruptedJobs {
+				failJob(database, job.Jobid, "Interrupted by restart")
+				DeleteRecord(database, &job)
+				continue
+			}
+
+			status.Status = pending
+			status.Message = "Interrupted by restart, trying again"
+			updateJob(database, &status)
+		}
+
+		inQueue[job.Jobid] = true
+	}
+
+	orphans := make([]string, 0)
+
+	err = database.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(printjobTable)).ForEach(func(key, value []byte) error {
+			var status struct {
+				Done bool `json:"done"`
+			}
+
+			if json5.Unmarshal(value, &status) == nil && !status.Done && !inQueue[string(key)] {
+				orphans = append(orphans, string(key))
+			}
+
+			return nil
+		})
+	})
+
+	for _, jobID := range orphans {
+		failJob(database, jobID, "Interrupted by restart")
+	}
+
+	if len(inQueue) > 0 || len(orphans) > 0 {
+		log.Printf("Recovered %v queued jobs, failed %v orphaned jobs", len(inQueue), len(orphans))
+	}
+
+	return err
+}
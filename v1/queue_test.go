@@ -0,0 +1,78 @@
+package zplorama
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func createTestDB(t *testing.T) *bolt.DB {
+	db := createDB(filepath.Join(t.TempDir(), "backend.boltdb"))
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestRecoverQueue(t *testing.T) {
+	cases := []struct {
+		name    string
+		retry   bool
+		printer string
+		// Job as the restart found it; an empty status means no record at all
+		status pictureStatus
+		done   bool
+		queued bool
+		// Job afterwards
+		wantStatus  pictureStatus
+		wantMessage string
+		wantDone    bool
+		wantQueued  bool
+	}{
+		{"waiting", false, "test", pending, false, true, pending, "Job created", false, true},
+		{"interrupted", false, "test", processing, false, true, failed, "Interrupted by restart", true, false},
+		{"interrupted and retried", true, "test", processing, false, true, pending, "Interrupted by restart, trying again", false, true},
+		{"printer removed", false, "gone", pending, false, true, failed, "Printer gone is no longer configured", true, false},
+		{"already finished", false, "test", succeeded, true, true, succeeded, "Job created", true, false},
+		{"no record", false, "test", "", false, true, "", "", false, false},
+		{"orphaned while printing", false, "test", processing, false, false, failed, "Interrupted by restart", true, false},
+		{"orphaned while waiting", true, "test", pending, false, false, failed, "Interrupted by restart", true, false},
+		{"finished", false, "test", failed, true, false, failed, "Job created", true, false},
+	}
+
+	saved := Config.RetryInterruptedJobs
+	defer func() { Config.RetryInterruptedJobs = saved }()
+
+	workers := map[string]*printerWorker{"test": {name: "test"}}
+
+	for _, test := range cases {
+		db := createTestDB(t)
+		Config.RetryInterruptedJobs = test.retry
+
+		if test.status != "" {
+			updateJob(db, &printJobStatus{Jobid: "job", Printer: test.printer, Status: test.status, Done: test.done, Message: "Job created"})
+		}
+
+		if test.queued {
+			if err := enqueueJob(db, &printJobRequest{Printer: test.printer, jobid: "job"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := recoverQueue(db, workers); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		status := printJobStatus{Jobid: "job"}
+		GetRecord(db, &status)
+
+		if status.Status != test.wantStatus || status.Message != test.wantMessage || status.Done != test.wantDone {
+			t.Errorf("%v: got %v %q (done %v), want %v %q (done %v)", test.name, status.Status, status.Message, status.Done, test.wantStatus, test.wantMessage, test.wantDone)
+		}
+
+		if queued := countQueuedJobs(db, test.printer) > 0; queued != test.wantQueued {
+			t.Errorf("%v: got queued %v, want %v", test.name, queued, test.wantQueued)
+		}
+	}
+}
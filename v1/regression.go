@@ -0,0 +1,384 @@
+package zplorama
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/disintegration/imaging"
+	"github.com/labstack/echo"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+const (
+	// Width pictures are compared (and diffs drawn) at
+	compareWidth = 600
+	// Width the first, rough alignment is found at
+	alignWidth = 150
+	// How far out of place, as a fraction of the width, a label can be and still be lined up
+	alignSlack = 0.08
+	// How different (0-255) a pixel has to be to count as changed
+	changeThreshold = 48
+)
+
+// A job someone has marked as what a label should look like
+type baselineRecord struct {
+	Jobid   string `json:"jobid"`
+	Name    string `json:"name"`
+	Printer string `json:"printer"`
+	Media   string `json:"media"`
+	Created string `json:"created"`
+}
+
+// Make this struct boltable
+func (*baselineRecord) Table() string {
+	return baselineTable
+}
+
+func (baseline *baselineRecord) Key() string {
+	return baseline.Jobid
+}
+
+// Two pictures of a label, lined up and compared
+type labelComparison struct {
+	similarity float64
+	diff       *image.NRGBA
+}
+
+// Grayscale and scale a picture down to width for comparing
+func comparisonImage(picture image.Image, width, height int) *image.NRGBA {
+	return imaging.Grayscale(imaging.Blur(imaging.Resize(picture, width, height, imaging.Box), 0.75))
+}
+
+// How bright the bare label is in a grayscale picture: the median, since
+// most of a label is left blank
+func paperLevel(picture *image.NRGBA) int {
+	var histogram [256]int
+	width, height := picture.Bounds().Dx(), picture.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			histogram[picture.Pix[y*picture.Stride+x*4]]++
+		}
+	}
+
+	seen := 0
+	for level, count := range histogram {
+		seen += count
+
+		if seen*2 >= width*height {
+			return level
+		}
+	}
+
+	return 255
+}
+
+// Mean difference between two same-sized grayscale pictures with current moved by dx, dy
+func shiftedDifference(baseline, current *image.NRGBA, dx, dy int) float64 {
+	width, height := baseline.Bounds().Dx(), baseline.Bounds().Dy()
+	total, count := 0, 0
+
+	for y := 0; y < height; y++ {
+		cy := y - dy
+		if cy < 0 || cy >= height {
+			continue
+		}
+
+		for x := 0; x < width; x++ {
+			cx := x - dx
+			if cx < 0 || cx >= width {
+				continue
+			}
+
+			difference := int(baseline.Pix[y*baseline.Stride+x*4]) - int(current.Pix[cy*current.Stride+cx*4])
+			if difference < 0 {
+				difference = -difference
+			}
+
+			total += difference
+			count++
+		}
+	}
+
+	if count == 0 {
+		return math.MaxFloat64
+	}
+
+	return float64(total) / float64(count)
+}
+
+// The offset that best lines current up with baseline, searching around a guess
+func bestShift(baseline, current *image.NRGBA, guessX, guessY, slack int) (int, int) {
+	bestX, bestY := guessX, guessY
+	best := math.MaxFloat64
+
+	for dy := guessY - slack; dy <= guessY+slack; dy++ {
+		for dx := guessX - slack; dx <= guessX+slack; dx++ {
+			if difference := shiftedDifference(baseline, current, dx, dy); difference < best {
+				best, bestX, bestY = difference, dx, dy
+			}
+		}
+	}
+
+	return bestX, bestY
+}
+
+// Line a new picture of a label up with a baseline one, score how alike
+// they are and draw where they differ: red where there's ink that wasn't
+// there before, blue where ink has gone missing
+func compareLabels(baselinePNG, currentPNG []byte) (labelComparison, error) {
+	baselinePicture, err := imaging.Decode(bytes.NewBuffer(baselinePNG))
+
+	if err != nil {
+		return labelComparison{}, fmt.Errorf("Could not read baseline picture: %v", err)
+	}
+
+	currentPicture, err := imaging.Decode(bytes.NewBuffer(currentPNG))
+
+	if err != nil {
+		return labelComparison{}, fmt.Errorf("Could not read picture: %v", err)
+	}
+
+	bounds := baselinePicture.Bounds()
+	if bounds.Dx() < 1 || bounds.Dy() < 1 {
+		return labelComparison{}, errors.New("Baseline picture is empty")
+	}
+
+	// Both labels get scaled to the baseline's shape so a slightly different crop still lines up
+	width := compareWidth
+	height := int(math.Max(1, math.Round(float64(bounds.Dy())*float64(width)/float64(bounds.Dx()))))
+	roughHeight := int(math.Max(1, math.Round(float64(height)*alignWidth/float64(width))))
+
+	roughX, roughY := bestShift(
+		comparisonImage(baselinePicture, alignWidth, roughHeight),
+		comparisonImage(currentPicture, alignWidth, roughHeight),
+		0, 0, int(alignWidth*alignSlack))
+
+	baseline := comparisonImage(baselinePicture, width, height)
+	current := comparisonImage(currentPicture, width, height)
+	scale := width / alignWidth
+	dx, dy := bestShift(baseline, current, roughX*scale, roughY*scale, scale)
+
+	diff := image.NewNRGBA(image.Rect(0, 0, width, height))
+	baselinePaper, currentPaper := paperLevel(baseline), paperLevel(current)
+
+	// Only dots with ink on them in either picture count towards the score,
+	// or the blank space most labels are would make any two look alike
+	changed, inked := 0, 0
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			was := int(baseline.Pix[y*baseline.Stride+x*4])
+			now := 255
+
+			cx, cy := x-dx, y-dy
+			if cx >= 0 && cx < width && cy >= 0 && cy < height {
+				now = int(current.Pix[cy*current.Stride+cx*4])
+			}
+
+			// Fade the label out so the changes stand out
+			faded := uint8(255 - (255-now)/4)
+			hasInk := baselinePaper-was > changeThreshold || currentPaper-now > changeThreshold
+
+			if hasInk {
+				inked++
+			}
+
+			switch {
+			case was-now > changeThreshold:
+				diff.SetNRGBA(x, y, color.NRGBA{R: 220, G: 30, B: 30, A: 255})
+			case now-was > changeThreshold:
+				diff.SetNRGBA(x, y, color.NRGBA{R: 30, G: 90, B: 220, A: 255})
+			default:
+				diff.SetNRGBA(x, y, color.NRGBA{R: faded, G: faded, B: faded, A: 255})
+				continue
+			}
+
+			if hasInk {
+				changed++
+			}
+		}
+	}
+
+	similarity := 1.0
+	if inked > 0 {
+		similarity = 1 - float64(changed)/float64(inked)
+	}
+
+	return labelComparison{
+		similarity: similarity,
+		diff:       diff,
+	}, nil
+}
+
+// The picture from a job's first camera
+func jobPicture(database *bolt.DB, jobID string) ([]byte, error) {
+	job := printJobStatus{Jobid: jobID}
+
+	if GetRecord(database, &job) != nil {
+		return nil, fmt.Errorf("Job %v not found", jobID)
+	} else if !job.Done || len(job.Images) == 0 || job.Images[0].Error != "" {
+		return nil, fmt.Errorf("Job %v doesn't have a picture", jobID)
+	}
+
+	return base64.StdEncoding.DecodeString(job.Images[0].ImageB64)
+}
+
+// Compare a job's picture with its baseline's, noting how it went in the job
+func compareWithBaseline(database *bolt.DB, status *printJobStatus, imageBytes []byte) {
+	baselinePNG, err := jobPicture(database, status.ComparedTo)
+
+	if err == nil {
+		var comparison labelComparison
+		comparison, err = compareLabels(baselinePNG, imageBytes)
+		status.Similarity = comparison.similarity
+	}
+
+	if err != nil {
+		status.Log = append(status.Log, fmt.Sprintf("Could not compare with baseline: %v", err))
+	} else {
+		status.Log = append(status.Log, fmt.Sprintf("%.2f%% similar to baseline %v", status.Similarity*100, status.ComparedTo))
+	}
+}
+
+func listBaselines(database *bolt.DB) ([]baselineRecord, error) {
+	baselines := make([]baselineRecord, 0)
+
+	err := database.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(baselineTable)).ForEach(func(key, value []byte) error {
+			var baseline baselineRecord
+
+			if err := json5.Unmarshal(value, &baseline); err != nil {
+				return err
+			}
+
+			baselines = append(baselines, baseline)
+			return nil
+		})
+	})
+
+	// Newest first
+	sort.Slice(baselines, func(i, j int) bool {
+		return baselines[i].Created > baselines[j].Created
+	})
+
+	return baselines, err
+}
+
+func getBaselines(database *bolt.DB) func(echo.Context) error {
+	return func(c echo.Context) error {
+		baselines, err := listBaselines(database)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, baselines)
+	}
+}
+
+// POST /job/:id/baseline with a name marks the job as a baseline
+func markBaseline(database *bolt.DB) func(echo.Context) error {
+	return func(c echo.Context) error {
+		job := printJobStatus{Jobid: c.Param("id")}
+
+		if GetRecord(database, &job) != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job not found"})
+		} else if job.Status != succeeded {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: "Only jobs that succeeded can be baselines"})
+		}
+
+		request := struct {
+			Name string `json:"name" form:"name" query:"name"`
+		}{}
+		c.Bind(&request)
+
+		if strings.TrimSpace(request.Name) == "" {
+			request.Name = job.Jobid
+		}
+
+		baseline := baselineRecord{
+			Jobid:   job.Jobid,
+			Name:    strings.TrimSpace(request.Name),
+			Printer: job.Printer,
+			Media:   job.Media,
+			Created: time.Now().Format(time.RFC3339),
+		}
+
+		err := PutRecord(database, &baseline)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		}
+
+		job.BaselineName = baseline.Name
+		updateJob(database, &job)
+
+		return c.JSON(http.StatusOK, job)
+	}
+}
+
+// DELETE /job/:id/baseline stops a job being a baseline
+func unmarkBaseline(database *bolt.DB) func(echo.Context) error {
+	return func(c echo.Context) error {
+		job := printJobStatus{Jobid: c.Param("id")}
+
+		if GetRecord(database, &job) != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job not found"})
+		}
+
+		err := DeleteRecord(database, &baselineRecord{Jobid: job.Jobid})
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		}
+
+		job.BaselineName = ""
+		updateJob(database, &job)
+
+		return c.JSON(http.StatusOK, job)
+	}
+}
+
+// GET /job/:id/diff/:baseline.png draws how a job's label differs from another's
+func getJobDiff(database *bolt.DB) func(echo.Context) error {
+	return func(c echo.Context) error {
+		baselineID := strings.TrimSuffix(c.Param("baseline"), ".png")
+
+		currentPNG, err := jobPicture(database, c.Param("id"))
+
+		if err != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
+		}
+
+		baselinePNG, err := jobPicture(database, baselineID)
+
+		if err != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
+		}
+
+		comparison, err := compareLabels(baselinePNG, currentPNG)
+
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, errJSON{Errmsg: err.Error()})
+		}
+
+		var diffImage bytes.Buffer
+		imaging.Encode(&diffImage, comparison.diff, imaging.PNG)
+
+		c.Response().Header().Set("Cache-Control", "max-age=31536000")
+		c.Response().Header().Set("X-Similarity", fmt.Sprintf("%.4f", comparison.similarity))
+
+		return c.Blob(http.StatusOK, "image/png", diffImage.Bytes())
+	}
+}
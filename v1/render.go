@@ -0,0 +1,195 @@
+package zplorama
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/jasonbot/zpl-o-rama/v1/zpl"
+	"github.com/labstack/echo"
+)
+
+var errNothingToRender = errors.New("No labels in the ZPL to draw")
+
+// A printer that draws its labels instead of printing them, so the print
+// server can run without any hardware. The render camera hands back the
+// last label it drew.
+type renderPrinterTransport struct {
+	lock sync.Mutex
+	dpi  int
+	// Settings like ^PW and ^LL stick around between sends the way they
+	// would on a printer, so what was sent last gets drawn ahead of what's sent now
+	previous string
+	label    []byte
+}
+
+func (t *renderPrinterTransport) Send(source string) (err error) {
+	// Control commands on their own (~JA cancelling, ~HS asking for status)
+	// don't draw anything or change how the next label comes out
+	if controlOnly(source) {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Fail the job rather than the worker if drawing it goes wrong
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Render printer could not draw the label: %v", r)
+		}
+	}()
+
+	label, err := renderLabelPNG(t.previous+source, zpl.RenderOptions{DPI: t.dpi})
+
+	if err == errNothingToRender {
+		// A printer ignores ZPL with no labels in it too
+		err = nil
+	} else if err == nil {
+		t.label = label
+	}
+
+	t.previous = source
+
+	return err
+}
+
+// Whether ZPL is nothing but ~ control commands
+func controlOnly(source string) bool {
+	document := zpl.Parse(source)
+
+	if len(document.Labels) > 0 || len(document.Stray) > 0 || len(document.Commands) == 0 {
+		return false
+	}
+
+	for _, command := range document.Commands {
+		if !command.Control {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t *renderPrinterTransport) lastLabel() []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.label
+}
+
+// Returns the label a render transport printer last drew
+type renderedLabelCamera struct {
+	printer *renderPrinterTransport
+}
+
+func (camera *renderedLabelCamera) Capture() ([]byte, error) {
+	if camera.printer == nil {
+		return nil, errors.New("Render camera isn't attached to a render printer")
+	}
+
+	label := camera.printer.lastLabel()
+
+	if label == nil {
+		return nil, errors.New("Render printer hasn't drawn anything yet")
+	}
+
+	return label, nil
+}
+
+// The resolution and media size in dots of the printer's labels
+func (worker *printerWorker) renderOptions(media string) zpl.RenderOptions {
+	profile, err := mediaProfileNamed(worker.mediaName(media))
+
+	if err != nil {
+		return zpl.RenderOptions{DPI: worker.config.DPI}
+	}
+
+	width, length := profile.dots(worker.config.DPI)
+
+	return zpl.RenderOptions{DPI: profile.dpi(worker.config.DPI), Width: width, Length: length}
+}
+
+// Draw the last label in some ZPL, the one the camera would see, as a PNG
+func renderLabelPNG(source string, options zpl.RenderOptions) (rendered []byte, err error) {
+	// A bug drawing one odd label shouldn't take the whole server down with it
+	defer func() {
+		if r := recover(); r != nil {
+			rendered, err = nil, fmt.Errorf("Could not draw the ZPL: %v", r)
+		}
+	}()
+
+	label := zpl.Parse(source).RenderLast(options)
+
+	if label == nil {
+		return nil, errNothingToRender
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, label)
+
+	return buf.Bytes(), err
+}
+
+// POST /render draws ZPL as it would come out of the printer and media it's for
+func renderZPL(workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		renderRequest := new(printJobRequest)
+		c.Bind(renderRequest)
+
+		if renderRequest.Printer == "" {
+			renderRequest.Printer = defaultPrinter(workers)
+		}
+
+		worker, ok := workers[renderRequest.Printer]
+
+		if !ok {
+			return c.JSON(http.StatusBadRequest, errJSON{Errmsg: fmt.Sprintf("Unknown printer %v", renderRequest.Printer)})
+		}
+
+		rendered, err := renderLabelPNG(renderRequest.ZPL, worker.renderOptions(renderRequest.Media))
+
+		if err == errNothingToRender {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		}
+
+		return c.Blob(http.StatusOK, "image/png", rendered)
+	}
+}
+
+// GET /job/:id/rendered.png draws the job's ZPL
+func getJobRendering(database *bolt.DB, workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		job := printJobStatus{Jobid: c.Param("id")}
+
+		if GetRecord(database, &job) != nil {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job not found"})
+		}
+
+		// Printers that have gone from the config get drawn at the defaults
+		options := zpl.RenderOptions{}
+		if worker, ok := workers[job.Printer]; ok {
+			options = worker.renderOptions(job.Media)
+		}
+
+		rendered, err := renderLabelPNG(job.ZPL, options)
+
+		if err == errNothingToRender {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+		}
+
+		// Drawn for the printer's media and DPI as they're configured now,
+		// which can change under the same URL
+		c.Response().Header().Set("Cache-Control", "no-cache")
+
+		return c.Blob(http.StatusOK, "image/png", rendered)
+	}
+}
@@ -0,0 +1,262 @@
+package zplorama
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"github.com/labstack/echo"
+)
+
+const (
+	// The rendition stored with the job as image_small
+	previewRendition = "preview"
+
+	pngFormat  = "png"
+	jpegFormat = "jpeg"
+
+	defaultJPEGQuality = 85
+	// ?w= widths get rounded up to a multiple of this so the cache isn't full of 1px variations
+	resizeWidthStep = 16
+)
+
+var defaultRenditions = map[string]renditionConfig{
+	"thumb":          {Width: 160, Format: jpegFormat, Quality: 80},
+	previewRendition: {Width: 800, Format: jpegFormat, Quality: defaultJPEGQuality},
+}
+
+var defaultSrcsetWidths = []int{400, 800, 1200, 1600}
+
+func renditionNamed(name string) (renditionConfig, error) {
+	if rendition, ok := Config.Renditions[name]; ok {
+		return rendition, nil
+	} else if rendition, ok := defaultRenditions[name]; ok {
+		return rendition, nil
+	}
+
+	return renditionConfig{}, fmt.Errorf("Unknown rendition %v", name)
+}
+
+func (rendition renditionConfig) contentType() string {
+	if rendition.Format == jpegFormat || rendition.Format == "jpg" {
+		return "image/jpeg"
+	}
+
+	return "image/png"
+}
+
+// Scale a picture down to the rendition's width (never up) in its format
+func (rendition renditionConfig) render(data []byte) ([]byte, error) {
+	picture, err := imaging.Decode(bytes.NewBuffer(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rendition.Width > 0 && rendition.Width < picture.Bounds().Dx() {
+		picture = imaging.Resize(picture, rendition.Width, 0, imaging.Lanczos)
+	}
+
+	var rendered bytes.Buffer
+
+	if rendition.contentType() == "image/jpeg" {
+		quality := rendition.Quality
+		if quality <= 0 || quality > 100 {
+			quality = defaultJPEGQuality
+		}
+
+		err = imaging.Encode(&rendered, picture, imaging.JPEG, imaging.JPEGQuality(quality))
+	} else {
+		err = imaging.Encode(&rendered, picture, imaging.PNG)
+	}
+
+	return rendered.Bytes(), err
+}
+
+// Make the preview rendition of a base64 picture
+func shrinkImage(imageB64 string) (string, error) {
+	data, _ := base64.StdEncoding.DecodeString(imageB64)
+
+	rendition, err := renditionNamed(previewRendition)
+
+	if err != nil {
+		return "", err
+	}
+
+	rendered, err := rendition.render(data)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(rendered), nil
+}
+
+// Rendered pictures, least recently used thrown out first once they add up to too many bytes
+type renditionCache struct {
+	lock     sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cachedRendition struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+var renditions = &renditionCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+func (cache *renditionCache) get(key string) (cachedRendition, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	element, ok := cache.entries[key]
+
+	if !ok {
+		return cachedRendition{}, false
+	}
+
+	cache.order.MoveToFront(element)
+
+	return element.Value.(cachedRendition), true
+}
+
+func (cache *renditionCache) put(entry cachedRendition) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.maxBytes == 0 {
+		cache.maxBytes = Config.RenditionCacheMB << 20
+		if cache.maxBytes <= 0 {
+			cache.maxBytes = 64 << 20
+		}
+	}
+
+	if _, ok := cache.entries[entry.key]; ok || len(entry.data) > cache.maxBytes {
+		return
+	}
+
+	cache.entries[entry.key] = cache.order.PushFront(entry)
+	cache.size += len(entry.data)
+
+	for cache.size > cache.maxBytes {
+		oldest := cache.order.Back()
+		evicted := cache.order.Remove(oldest).(cachedRendition)
+
+		delete(cache.entries, evicted.key)
+		cache.size -= len(evicted.data)
+	}
+}
+
+// A ?w= width rounded up to the cache's step, or 0 for none
+func requestedWidth(c echo.Context) (int, error) {
+	value := c.QueryParam("w")
+
+	if value == "" {
+		return 0, nil
+	}
+
+	width, err := strconv.Atoi(value)
+
+	if err != nil || width <= 0 {
+		return 0, fmt.Errorf("Bad width %v", value)
+	}
+
+	return (width + resizeWidthStep - 1) / resizeWidthStep * resizeWidthStep, nil
+}
+
+// Render (or fetch from the cache) one of a job's pictures; only finished
+// jobs are cached since the pictures don't change after that
+func serveRendition(c echo.Context, job printJobStatus, image jobImage, name string, rendition renditionConfig) error {
+	key := fmt.Sprintf("%v/%v/%v/%v/%v/%v", job.Jobid, image.Camera, name, rendition.Width, rendition.Format, rendition.Quality)
+
+	if cached, ok := renditions.get(key); ok {
+		return c.Blob(http.StatusOK, cached.contentType, cached.data)
+	}
+
+	data, _ := base64.StdEncoding.DecodeString(image.ImageB64)
+	rendered, err := rendition.render(data)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errJSON{Errmsg: err.Error()})
+	}
+
+	if job.Done {
+		renditions.put(cachedRendition{key: key, contentType: rendition.contentType(), data: rendered})
+	}
+
+	return c.Blob(http.StatusOK, rendition.contentType(), rendered)
+}
+
+// GET /job/:id/rendition/:name, with ?w= to pick a different width and
+// ?camera= for a camera other than the first
+func displayJobRendition(c echo.Context) error {
+	job, err := fetchJobCall(c.Param("id"))
+
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
+	}
+
+	image, ok := job.image(c.QueryParam("camera"))
+
+	if !ok {
+		return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Job has no picture from that camera"})
+	}
+
+	name := c.Param("name")
+	rendition, err := renditionNamed(name)
+
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errJSON{Errmsg: err.Error()})
+	}
+
+	width, err := requestedWidth(c)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errJSON{Errmsg: err.Error()})
+	} else if width > 0 {
+		rendition.Width = width
+	}
+
+	if job.Done {
+		c.Response().Header().Set("Cache-Control", "max-age=31536000")
+	} else {
+		c.Response().Header().Set("Cache-Control", "max-age=0")
+	}
+
+	return serveRendition(c, job, image, name, rendition)
+}
+
+// srcset for a job's picture from a camera in the preview format at each configured width
+func jobSrcset(jobID string, camera string) string {
+	widths := Config.SrcsetWidths
+	if len(widths) == 0 {
+		widths = defaultSrcsetWidths
+	}
+
+	widths = append([]int(nil), widths...)
+	sort.Ints(widths)
+
+	query := ""
+	if camera != "" {
+		query = "&camera=" + url.QueryEscape(camera)
+	}
+
+	sources := make([]string, 0, len(widths))
+	for _, width := range widths {
+		sources = append(sources, fmt.Sprintf("/job/%v/rendition/%v?w=%v%v %vw", jobID, previewRendition, width, query, width))
+	}
+
+	return strings.Join(sources, ", ")
+}
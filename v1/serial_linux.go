@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package zplorama
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+}
+
+// Put the port in raw 8N1 mode at the requested speed
+func configureSerialPort(port *os.File, baudRate int) error {
+	speed, ok := baudRates[baudRate]
+
+	if !ok {
+		return fmt.Errorf("Unsupported baud rate %v", baudRate)
+	}
+
+	fd := int(port.Fd())
+
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+
+	if err != nil {
+		return err
+	}
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB | unix.CBAUD
+	termios.Cflag |= unix.CS8 | unix.CLOCAL | unix.CREAD | speed
+	termios.Ispeed = speed
+	termios.Ospeed = speed
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package zplorama
+
+import "os"
+
+// Outside of Linux we trust the port has already been set up (e.g. with stty)
+func configureSerialPort(port *os.File, baudRate int) error {
+	return nil
+}
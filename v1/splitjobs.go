@@ -0,0 +1,150 @@
+package zplorama
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/uuid"
+	"github.com/jasonbot/zpl-o-rama/v1/zpl"
+)
+
+// Labels of the same split job can finish close together; only one gets
+// copied into the parent job at a time
+var subjobLock sync.Mutex
+
+// Queue each label in the ZPL up as a job of its own, in order, under one
+// parent job that keeps track of how they all did. ZPL with only one label
+// is just a job.
+func submitSplitJob(database *bolt.DB, worker *printerWorker, printRequest *printJobRequest) (string, error) {
+	pieces := zpl.Parse(printRequest.ZPL).SplitLabels()
+
+	if len(pieces) < 2 {
+		return submitJob(database, worker, printRequest)
+	}
+
+	parent := printJobStatus{
+		Jobid:   uuid.NewString(),
+		Printer: printRequest.Printer,
+		Media:   worker.mediaName(printRequest.Media),
+		Status:  pending,
+		ZPL:     printRequest.ZPL,
+		Lint:    zpl.Lint(printRequest.ZPL, worker.lintOptions(printRequest.Media)),
+		Created: time.Now().Format(time.RFC3339),
+		Updated: time.Now().Format(time.RFC3339),
+		Author:  printRequest.Author,
+		Message: fmt.Sprintf("Job split into %v labels", len(pieces)),
+		Done:    false,
+	}
+
+	requests := make([]printJobRequest, 0, len(pieces))
+
+	for index, piece := range pieces {
+		request := *printRequest
+		request.ZPL = piece
+		request.Split = false
+		request.jobid = uuid.NewString()
+		request.parent = parent.Jobid
+		request.label = index + 1
+
+		requests = append(requests, request)
+		parent.Subjobs = append(parent.Subjobs, subjobResult{
+			Jobid:   request.jobid,
+			Label:   request.label,
+			Status:  pending,
+			Message: "Job created",
+		})
+	}
+
+	// Every label is listed before any of them can start, so the parent can't look done early
+	updateJob(database, &parent)
+
+	// Keep going after a label fails to queue: the ones after it get marked failed too
+	var firstErr error
+	for index := range requests {
+		if _, err := submitJob(database, worker, &requests[index]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return parent.Jobid, firstErr
+}
+
+// Copy how a label is getting on into its parent job
+func updateParentJob(db *bolt.DB, job *printJobStatus) {
+	subjobLock.Lock()
+	defer subjobLock.Unlock()
+
+	parent := printJobStatus{Jobid: job.Parent}
+
+	if GetRecord(db, &parent) != nil {
+		return
+	}
+
+	for index := range parent.Subjobs {
+		if parent.Subjobs[index].Jobid == job.Jobid {
+			parent.Subjobs[index].Status = job.Status
+			parent.Subjobs[index].Message = job.Message
+			parent.Subjobs[index].Done = job.Done
+		}
+	}
+
+	parent.summarizeSubjobs()
+	updateJob(db, &parent)
+}
+
+// A split job is done once all its labels are, and failed if any of them failed
+func (parent *printJobStatus) summarizeSubjobs() {
+	counts := make(map[pictureStatus]int)
+	done := 0
+
+	for _, subjob := range parent.Subjobs {
+		counts[subjob.Status]++
+
+		if subjob.Done {
+			done++
+		}
+	}
+
+	total := len(parent.Subjobs)
+	parent.Done = done == total
+
+	switch {
+	case counts[pending] == total:
+		parent.Status = pending
+	case !parent.Done:
+		parent.Status = processing
+		parent.Message = fmt.Sprintf("%v of %v labels done", done, total)
+	case counts[failed] > 0:
+		parent.Status = failed
+		parent.Message = fmt.Sprintf("%v of %v labels failed", counts[failed], total)
+	case counts[cancelled] > 0:
+		parent.Status = cancelled
+		parent.Message = fmt.Sprintf("%v of %v labels cancelled", counts[cancelled], total)
+	default:
+		parent.Status = succeeded
+		parent.Message = fmt.Sprintf("All %v labels printed", total)
+	}
+}
+
+// Cancel every label of a split job that hasn't finished. The last ones go
+// first, so the printer doesn't move on to the next label while the one it's
+// on is being stopped.
+func (worker *printerWorker) cancelSubjobs(database *bolt.DB, parent *printJobStatus) error {
+	for index := len(parent.Subjobs) - 1; index >= 0; index-- {
+		subjob := printJobStatus{Jobid: parent.Subjobs[index].Jobid}
+
+		if GetRecord(database, &subjob) != nil || subjob.Done {
+			continue
+		}
+
+		if err := worker.cancel(database, &subjob); err != nil {
+			return err
+		}
+	}
+
+	GetRecord(database, parent)
+
+	return nil
+}
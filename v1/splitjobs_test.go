@@ -0,0 +1,49 @@
+package zplorama
+
+import "testing"
+
+// One letter per label: Pending, Running, Succeeded, Failed, Cancelled, or
+// c for cancelled but still taking its picture
+var subjobLetters = map[rune]subjobResult{
+	'P': {Status: pending},
+	'R': {Status: processing},
+	'S': {Status: succeeded, Done: true},
+	'F': {Status: failed, Done: true},
+	'C': {Status: cancelled, Done: true},
+	'c': {Status: cancelled},
+}
+
+func TestSummarizeSubjobs(t *testing.T) {
+	cases := []struct {
+		subjobs     string
+		wantStatus  pictureStatus
+		wantMessage string
+		wantDone    bool
+	}{
+		{"PPP", pending, "Labels queued", false},
+		{"RPP", processing, "0 of 3 labels done", false},
+		{"SRP", processing, "1 of 3 labels done", false},
+		{"SFP", processing, "2 of 3 labels done", false},
+		{"Sc", processing, "1 of 2 labels done", false},
+		{"SSS", succeeded, "All 3 labels printed", true},
+		{"SFS", failed, "1 of 3 labels failed", true},
+		{"FCF", failed, "2 of 3 labels failed", true},
+		{"SCC", cancelled, "2 of 3 labels cancelled", true},
+	}
+
+	for _, test := range cases {
+		parent := printJobStatus{Status: pending, Message: "Labels queued"}
+
+		for index, letter := range test.subjobs {
+			subjob := subjobLetters[letter]
+			subjob.Label = index + 1
+			parent.Subjobs = append(parent.Subjobs, subjob)
+		}
+
+		parent.summarizeSubjobs()
+
+		if parent.Status != test.wantStatus || parent.Message != test.wantMessage || parent.Done != test.wantDone {
+			t.Errorf("%v: got %v %q (done %v), want %v %q (done %v)", test.subjobs, parent.Status, parent.Message, parent.Done, test.wantStatus, test.wantMessage, test.wantDone)
+		}
+	}
+}
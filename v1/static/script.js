@@ -34,3 +34,132 @@ function updateJobStatus(jobid) {
     }
   })
 }
+
+function cancelJob(jobid) {
+  if (!window.confirm("Stop this job?")) {
+    return;
+  }
+
+  fetch(`/job/${jobid}`, { method: "DELETE" }).then((e) => {
+    e.json().then((j) => {
+      if (e.ok) {
+        handleHotwireResponse(j);
+      } else {
+        window.alert(j.error);
+      }
+    });
+  });
+}
+
+function markBaseline(jobid) {
+  const name = window.prompt("Name this baseline", jobid);
+
+  if (name === null) {
+    return;
+  }
+
+  fetch(`/job/${jobid}/baseline`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ name: name }),
+  }).then((e) => {
+    e.json().then((j) => {
+      if (e.ok) {
+        handleHotwireResponse(j);
+      } else {
+        window.alert(j.error);
+      }
+    });
+  });
+}
+
+function unmarkBaseline(jobid) {
+  fetch(`/job/${jobid}/baseline`, { method: "DELETE" }).then((e) => {
+    e.json().then((j) => {
+      if (e.ok) {
+        handleHotwireResponse(j);
+      } else {
+        window.alert(j.error);
+      }
+    });
+  });
+}
+
+// The ZPL, printer and media from the input form
+function zplFormRequest() {
+  const form = new FormData(document.getElementById("zplinput").form);
+
+  return {
+    ZPL: form.get("ZPL"),
+    printer: form.get("printer") || "",
+    media: form.get("media") || "",
+  };
+}
+
+function lintZPL() {
+  fetch("/lint", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(zplFormRequest()),
+  }).then((e) => {
+    e.json().then((j) => {
+      if (!e.ok) {
+        window.alert(j.error);
+        return;
+      }
+
+      const results = document.getElementById("zpllint");
+      const diagnostics = j.diagnostics || [];
+      results.innerHTML = "";
+
+      if (diagnostics.length == 0) {
+        results.textContent = "No problems found";
+        return;
+      }
+
+      const list = document.createElement("ul");
+      list.className = "lint";
+
+      for (const d of diagnostics) {
+        const item = document.createElement("li");
+        item.className = `lint-${d.severity}`;
+        item.textContent = `Line ${d.line}, column ${d.column}: ${d.severity} ${d.message}`;
+        list.appendChild(item);
+      }
+
+      results.appendChild(list);
+    });
+  });
+}
+
+let renderTimer = null;
+
+// Draw the ZPL being typed once typing stops for a moment
+function scheduleRender() {
+  window.clearTimeout(renderTimer);
+  renderTimer = window.setTimeout(renderZPL, 400);
+}
+
+function renderZPL() {
+  const rendered = document.getElementById("zplrendered");
+
+  fetch("/render", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(zplFormRequest()),
+  }).then((e) => {
+    if (!e.ok) {
+      rendered.hidden = true;
+      return;
+    }
+
+    e.blob().then((b) => {
+      if (rendered.src) {
+        URL.revokeObjectURL(rendered.src);
+      }
+
+      rendered.src = URL.createObjectURL(b);
+      rendered.hidden = false;
+    });
+  });
+}
@@ -22,36 +22,204 @@
 {{define "input-zpl-form"}}
     <h1>Let's render some ZPL on physical media!</h1>
     <form action="/print" method="post">
+        <div class="zpleditor">
+            <textarea name="ZPL" id="zplinput" rows="15" cols="80" name="ZPL" oninput="scheduleRender();"></textarea>
+            <img id="zplrendered" class="zplrendered" alt="How the ZPL should come out" hidden />
+        </div>
+        {{ if .Printers }}
+            <div>
+                <label for="printerselect">Printer</label>
+                <select name="printer" id="printerselect" onchange="scheduleRender();">
+                    {{ range .Printers }}
+                        <option value="{{ html .Name }}" {{ if .Default }}selected{{ end }}>{{ html .Name }} ({{ html .Media }}, {{ .DPI }} dpi)</option>
+                    {{ end }}
+                </select>
+                <span class="livelinks">
+                    Watch live:
+                    {{ range .Printers }}
+                        <a href="/printers/{{ html .Name }}/live" target="_blank">{{ html .Name }}</a>
+                    {{ end }}
+                </span>
+            </div>
+        {{ end }}
+        {{ if .Media }}
+            <div>
+                <label for="mediaselect">Media</label>
+                <select name="media" id="mediaselect" onchange="scheduleRender();">
+                    <option value="" selected>Printer's default</option>
+                    {{ range .Media }}
+                        <option value="{{ html .Name }}">{{ html .Name }} ({{ .Profile.Width }}x{{ .Profile.Length }}")</option>
+                    {{ end }}
+                </select>
+            </div>
+        {{ end }}
         <div>
-            <textarea name="ZPL" id="zplinput" rows="15" cols="80" name="ZPL"></textarea>
+            <input type="checkbox" name="burst" id="burstcheck" value="true" />
+            <label for="burstcheck">Record a time-lapse of the print</label>
         </div>
         <div>
+            <input type="checkbox" name="split" id="splitcheck" value="true" />
+            <label for="splitcheck">Print and photograph each label separately</label>
+        </div>
+        {{ if .Baselines }}
+            <div>
+                <label for="baselineselect">Compare to baseline</label>
+                <select name="baseline" id="baselineselect">
+                    <option value="" {{ if eq $.Baseline "" }}selected{{ end }}>None</option>
+                    {{ range .Baselines }}
+                        <option value="{{ html .Jobid }}" {{ if eq $.Baseline .Jobid }}selected{{ end }}>{{ html .Name }} ({{ html .Printer }}, {{ html .Created }})</option>
+                    {{ end }}
+                </select>
+            </div>
+        {{ end }}
+        <div>
+            <button type="button" onclick="lintZPL();">Check ZPL</button>
             <button type="submit" class="godoit">Go do it</button>
         </div>
     </form>
+    <div id="zpllint"></div>
 {{end}}
 
 {{define "job-status-part"}}
 
     <h2>ID: <span id="jobid">{{ .Jobid }}</span></h2>
     <div>
-        <p>Created <span id="jobcreated">{{ html .Created }}</span> by <span id="jobauthor">{{ html .Author }}</span></p>
+        <p>Created <span id="jobcreated">{{ html .Created }}</span> by <span id="jobauthor">{{ html .Author }}</span>{{ if ne .Printer "" }} on <span id="jobprinter">{{ html .Printer }}</span>{{ if ne .PrinterModel "" }} (<span id="jobprintermodel">{{ html .PrinterModel }}</span>{{ if ne .PrinterFirmware "" }}, firmware <span id="jobprinterfirmware">{{ html .PrinterFirmware }}</span>{{ end }}){{ end }}{{ end }}{{ if ne .Media "" }} using <span id="jobmedia">{{ html .Media }}</span> media{{ end }}</p>
         <p>
             <b>Job Status:</b> <span id="jobstatus" class="status-{{ html .Status }}">{{ html .Status }}</span>
             {{if not .Done }} <span class="spinner"></span> {{end}}
         </p>
+        {{ if ne .Parent "" }}
+            <p>Label {{ .Label }} of job <a id="jobparent" href="/job/{{ html .Parent }}">{{ html .Parent }}</a></p>
+        {{ end }}
+        {{ if .Rendered }}
+            <p id="jobrendered">Drawn from the ZPL by a render-only printer, not photographed</p>
+        {{ end }}
+        {{if not .Done }}
+            <p><button type="button" class="cancel" onclick="cancelJob('{{ html .Jobid }}');">Cancel job</button></p>
+        {{end}}
+        {{ if ne .BaselineName "" }}
+            <p>
+                Baseline <b id="jobbaselinename">{{ html .BaselineName }}</b>:
+                <a href="/home?baseline={{ html .Jobid }}">print against this baseline</a>
+                <button type="button" onclick="unmarkBaseline('{{ html .Jobid }}');">Stop using as baseline</button>
+            </p>
+        {{ else if and (eq .Status "SUCCEEDED") (not .Subjobs) }}
+            <p><button type="button" onclick="markBaseline('{{ html .Jobid }}');">Use as baseline</button></p>
+        {{ end }}
     </div>
+    {{ if .Subjobs }}
+    <h3>Labels</h3>
+    <ol id="jobsubjobs" class="subjobs">
+        {{ range .Subjobs }}
+            <li>
+                <a href="/job/{{ html .Jobid }}">Label {{ .Label }}</a>:
+                <span class="status-{{ html .Status }}">{{ html .Status }}</span>
+                {{ html .Message }}
+                {{ if .Done }}
+                    <div><img class="scanimage" src="/job/{{ html .Jobid }}/image.png?{{ .Status }}" alt="Label {{ .Label }}" /></div>
+                {{ end }}
+            </li>
+        {{ end }}
+    </ol>
+    {{ else }}
     <div id="zplimage" class="zplimage">
         {{ if .Done }} 
             <!-- ?{{ .Status }} is a cache-buster -->
-            <img class="scanimage" src="/job/{{ .Jobid }}/image.png?{{ .Status }}" alt="Your image" />
-            <p><a href="/job/{{ .Jobid }}/original.png" download>Download original size image</a></p>
+            {{ $job := . }}
+            {{ range $index, $image := .Images }}
+                {{ if gt (len $job.Images) 1 }}
+                    <h4>Camera <span class="jobcamera">{{ html .Camera }}</span></h4>
+                {{ end }}
+                <div class="sidebyside">
+                    <div class="overlaywrap">
+                        <img class="scanimage" src="/job/{{ $job.Jobid }}/image/{{ html .Camera }}.png?{{ $job.Status }}" srcset="{{ Srcset $job.Jobid .Camera }}" sizes="(max-width: 80em) 50vw, 40em" alt="Your image from camera {{ .Camera }}" />
+                        {{ if and (eq $index 0) $job.FieldOrigins }}
+                            <svg class="fieldorigins" viewBox="0 0 {{ $job.ImageWidth }} {{ $job.ImageHeight }}" preserveAspectRatio="none">
+                                {{ range $job.FieldOrigins }}
+                                    <circle cx="{{ .PixelX }}" cy="{{ .PixelY }}" r="0.8%"><title>^{{ .Command }}{{ .X }},{{ .Y }} {{ .Data }}</title></circle>
+                                {{ end }}
+                            </svg>
+                        {{ end }}
+                    </div>
+                    {{ if and (eq $index 0) (ne $job.ZPL "") (not $job.Rendered) }}
+                        <div class="renderedwrap">
+                            <img class="scanimage" src="/job/{{ $job.Jobid }}/rendered.png" alt="How the ZPL should have come out" title="How the ZPL should have come out" />
+                        </div>
+                    {{ end }}
+                </div>
+                {{ if ne .Error "" }}
+                    <p>Could not take a picture: <span class="jobcameraerror">{{ html .Error }}</span></p>
+                {{ else }}
+                    <p><a href="/job/{{ $job.Jobid }}/original.png?camera={{ .Camera }}" download>Download original size image</a>{{ if ne .ImageB64Raw "" }} | <a href="/job/{{ $job.Jobid }}/raw.png?camera={{ .Camera }}" download>Download uncropped photo</a>{{ end }}</p>
+                    {{ if ne .CaptureStarted "" }}
+                        <p>Picture taken <span class="jobcapturestarted">{{ html .CaptureStarted }}</span> in <span class="jobcapturems">{{ .CaptureMillis }}</span>ms</p>
+                    {{ end }}
+                {{ end }}
+            {{ else }}
+                <img class="scanimage" src="/job/{{ .Jobid }}/image.png?{{ .Status }}" alt="Your image" />
+            {{ end }}
+            {{ with .Measurement }}
+                <p>Label measured <span id="jobmeasured">{{ .WidthMM }} &times; {{ .LengthMM }}mm</span>, ZPL asked for <span id="jobexpected">{{ .ExpectedWidthMM }} &times; {{ .ExpectedLengthMM }}mm</span></p>
+            {{ end }}
+            {{ if .FieldOrigins }}
+                <p class="difflegend">Dots mark where the <code>^FO</code>/<code>^FT</code> field origins should have printed, going by calibration job <a href="/job/{{ html .Calibration }}">{{ html .Calibration }}</a></p>
+            {{ end }}
         {{ end }} 
 
         {{ if eq .ZPL "" }}
             <p>(Note: A job submitted with empty ZPL just takes a picture)</p>
         {{ end }}
     </div>
+    {{ end }}
+
+    {{ if and .Done .Frames }}
+        <h3>Time-lapse</h3>
+        <div id="jobtimelapse" class="zplimage">
+            <img class="scanimage" src="/job/{{ .Jobid }}/timelapse.gif" alt="Time-lapse of the label printing" />
+            <p class="frames">
+                Frames:
+                {{ $jobid := .Jobid }}
+                {{ range $index, $offset := .Frames }}
+                    <a href="/job/{{ $jobid }}/frames/{{ $index }}">{{ $offset }}ms</a>
+                {{ end }}
+            </p>
+        </div>
+    {{ end }}
+
+    {{ if and .Done (ne .ComparedTo "") }}
+        <h3>Compared to baseline</h3>
+        <div id="jobbaseline" class="zplimage">
+            <p><a href="/job/{{ html .ComparedTo }}">{{ html .ComparedTo }}</a>: <span id="jobsimilarity">{{ Percent .Similarity }}</span> similar</p>
+            <img class="scanimage" src="/job/{{ .Jobid }}/diff/{{ html .ComparedTo }}.png" alt="Differences from the baseline" />
+            <p class="difflegend"><span class="diff-added">Red</span> is new ink, <span class="diff-removed">blue</span> is ink that's gone missing</p>
+        </div>
+    {{ end }}
+
+    {{ if .Barcodes }}
+        <h3>Barcodes</h3>
+        <table id="jobbarcodes" class="barcodes">
+            <tr><th>Field</th><th>Type</th><th>Expected</th><th>Scanned</th><th>Result</th></tr>
+            {{ range .Barcodes }}
+                <tr>
+                    <td><code>{{ html .Command }}</code></td>
+                    <td>{{ html .Format }}</td>
+                    <td><code>{{ html .Expected }}</code></td>
+                    <td><code>{{ html .Decoded }}</code></td>
+                    <td class="barcode-{{ html .Status }}">{{ html .Status }}{{ if ne .Message "" }}: {{ html .Message }}{{ end }}</td>
+                </tr>
+            {{ end }}
+        </table>
+    {{ end }}
+
+    {{ if .Lint }}
+        <h3>ZPL problems</h3>
+        <ul id="joblint" class="lint">
+            {{ range .Lint }}
+                <li class="lint-{{ html .Severity }}">Line {{ .Line }}, column {{ .Column }}: <b>{{ html .Severity }}</b> {{ html .Message }}</li>
+            {{ end }}
+        </ul>
+    {{ end }}
 
     <h3>Job Run Log</h3>
     <div id="runlog">
@@ -61,6 +229,30 @@
     </div>
 {{end}}
 
+{{define "printer-live"}}
+    <div>
+        <a href="/home">&larr; Back to home</a>
+    </div>
+
+    <h1>Printer <span id="printername">{{ html .Name }}</span></h1>
+    <p>{{ html .Media }} media, {{ .DPI }} dpi, <span id="printerstate">{{ html .Worker.State }}</span>{{ if .Queued }} with {{ .Queued }} jobs queued{{ end }}</p>
+    {{ $printer := . }}
+    {{ range .Cameras }}
+        {{ if gt (len $printer.Cameras) 1 }}
+            <h4>Camera <span class="livecamera">{{ html . }}</span></h4>
+        {{ end }}
+        <div class="zplimage liveview">
+            <img class="scanimage" src="/printers/{{ html $printer.Name }}/live.mjpeg?camera={{ . }}" alt="Live view from camera {{ . }}" />
+        </div>
+    {{ end }}
+    <form method="post" action="/printers/{{ html .Name }}/calibrate">
+        <p>
+            {{ if ne .Calibrated "" }}Camera {{ html .Camera }} calibrated {{ html .Calibrated }}{{ else }}Camera {{ html .Camera }} not calibrated yet{{ end }}
+            <button type="submit">Print calibration label</button>
+        </p>
+    </form>
+{{end}}
+
 {{define "job-status"}}
     <div>
         <a href="/home">&larr; Back to home</a>
@@ -0,0 +1,358 @@
+package zplorama
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rawTransport    = "raw"
+	lpdTransport    = "lpd"
+	deviceTransport = "device"
+	serialTransport = "serial"
+	fileTransport   = "file"
+	renderTransport = "render"
+)
+
+const dialTimeout = 1 * time.Second
+const lpdTimeout = 30 * time.Second
+const queryTimeout = 2 * time.Second
+
+// PrinterTransport is how a ZPL stream makes its way to a physical printer
+type PrinterTransport interface {
+	Send(zpl string) error
+}
+
+// PrinterQuerier is a transport that can also read the printer's answers back;
+// complete tells it when it has read the whole response
+type PrinterQuerier interface {
+	Query(command string, complete func([]byte) bool) ([]byte, error)
+}
+
+var errQueryUnsupported = errors.New("Printer transport can't read responses from the printer")
+
+// Keep reading until the response is complete, the reader fails, or we give up.
+// The caller is expected to close the reader afterwards, which ends the goroutine.
+func readResponse(reader io.Reader, complete func([]byte) bool, timeout time.Duration) ([]byte, error) {
+	type chunk struct {
+		data []byte
+		err  error
+	}
+
+	chunks := make(chan chunk)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			buf := make([]byte, 1024)
+			n, err := reader.Read(buf)
+
+			select {
+			case chunks <- chunk{data: buf[:n], err: err}:
+			case <-done:
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var response []byte
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case c := <-chunks:
+			response = append(response, c.data...)
+
+			if complete(response) {
+				return response, nil
+			} else if c.err != nil {
+				return response, c.err
+			}
+		case <-deadline:
+			return response, errors.New("Timed out waiting for printer to respond")
+		}
+	}
+}
+
+func queryReadWriter(rw io.ReadWriter, command string, complete func([]byte) bool) ([]byte, error) {
+	_, err := rw.Write([]byte(command))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return readResponse(rw, complete, queryTimeout)
+}
+
+func newPrinterTransport(kind, address, lpdQueue string, baudRate int) (PrinterTransport, error) {
+	if address == "" {
+		return nil, errors.New("Printer address is empty")
+	}
+
+	switch kind {
+	case rawTransport, "tcp", "":
+		return &rawPrinterTransport{address: withDefaultPort(address, "9100")}, nil
+	case lpdTransport:
+		if lpdQueue == "" {
+			lpdQueue = "raw"
+		}
+		return &lpdPrinterTransport{address: withDefaultPort(address, "515"), queue: lpdQueue}, nil
+	case deviceTransport:
+		return &devicePrinterTransport{path: address}, nil
+	case serialTransport:
+		if baudRate == 0 {
+			baudRate = 9600
+		}
+		return &serialPrinterTransport{path: address, baudRate: baudRate}, nil
+	case fileTransport:
+		return &filePrinterTransport{path: address}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown printer transport %v", kind)
+}
+
+// Whether an error happened before anything was sent, so it's safe to try again
+func isConnectError(err error) bool {
+	var opError *net.OpError
+	var pathError *os.PathError
+
+	if errors.As(err, &opError) {
+		return opError.Op == "dial"
+	} else if errors.As(err, &pathError) {
+		return pathError.Op == "open"
+	}
+
+	return false
+}
+
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, port)
+	}
+
+	return address
+}
+
+// Raw TCP, usually port 9100 on the printer
+type rawPrinterTransport struct {
+	address string
+}
+
+func (t *rawPrinterTransport) Send(zpl string) error {
+	conn, err := net.DialTimeout("tcp", t.address, dialTimeout)
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(zpl))
+	return err
+}
+
+func (t *rawPrinterTransport) Query(command string, complete func([]byte) bool) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", t.address, dialTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return queryReadWriter(conn, command, complete)
+}
+
+// Line Printer Daemon protocol, RFC 1179
+type lpdPrinterTransport struct {
+	address string
+	queue   string
+}
+
+var lpdJobCounter uint32
+
+func lpdAck(conn net.Conn, step string) error {
+	ack := make([]byte, 1)
+
+	_, err := io.ReadFull(conn, ack)
+
+	if err != nil {
+		return err
+	}
+
+	if ack[0] != 0 {
+		return fmt.Errorf("LPD server refused %v (code %v)", step, ack[0])
+	}
+
+	return nil
+}
+
+func lpdSendFile(conn net.Conn, subcommand byte, name string, contents []byte) error {
+	_, err := fmt.Fprintf(conn, "%c%v %v\n", subcommand, len(contents), name)
+
+	if err != nil {
+		return err
+	}
+
+	err = lpdAck(conn, name)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(append(contents, 0))
+
+	if err != nil {
+		return err
+	}
+
+	return lpdAck(conn, name)
+}
+
+func (t *lpdPrinterTransport) Send(zpl string) error {
+	conn, err := net.DialTimeout("tcp", t.address, dialTimeout)
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(lpdTimeout))
+
+	// RFC 1179 caps host names at 31 octets
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "zplorama"
+	}
+	if len(host) > 31 {
+		host = host[:31]
+	}
+
+	jobNumber := atomic.AddUint32(&lpdJobCounter, 1) % 1000
+	dataFile := fmt.Sprintf("dfA%03d%v", jobNumber, host)
+	controlFile := fmt.Sprintf("cfA%03d%v", jobNumber, host)
+
+	// 'l' prints the data file verbatim, control characters and all
+	control := strings.Join([]string{
+		"H" + host,
+		"Pzplorama",
+		"l" + dataFile,
+		"U" + dataFile,
+		"Nlabel.zpl",
+	}, "\n") + "\n"
+
+	_, err = fmt.Fprintf(conn, "\x02%v\n", t.queue)
+
+	if err != nil {
+		return err
+	}
+
+	err = lpdAck(conn, "queue "+t.queue)
+
+	if err != nil {
+		return err
+	}
+
+	err = lpdSendFile(conn, '\x02', controlFile, []byte(control))
+
+	if err != nil {
+		return err
+	}
+
+	return lpdSendFile(conn, '\x03', dataFile, []byte(zpl))
+}
+
+// Local character device, e.g. /dev/usb/lp0
+type devicePrinterTransport struct {
+	path string
+}
+
+func (t *devicePrinterTransport) Send(zpl string) error {
+	device, err := os.OpenFile(t.path, os.O_WRONLY, 0)
+
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	_, err = device.Write([]byte(zpl))
+	return err
+}
+
+func (t *devicePrinterTransport) Query(command string, complete func([]byte) bool) ([]byte, error) {
+	device, err := os.OpenFile(t.path, os.O_RDWR, 0)
+
+	if err != nil {
+		return nil, err
+	}
+	defer device.Close()
+
+	return queryReadWriter(device, command, complete)
+}
+
+// Serial port, e.g. /dev/ttyUSB0
+type serialPrinterTransport struct {
+	path     string
+	baudRate int
+}
+
+func (t *serialPrinterTransport) Send(zpl string) error {
+	port, err := os.OpenFile(t.path, os.O_RDWR, 0)
+
+	if err != nil {
+		return err
+	}
+	defer port.Close()
+
+	err = configureSerialPort(port, t.baudRate)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = port.Write([]byte(zpl))
+	return err
+}
+
+func (t *serialPrinterTransport) Query(command string, complete func([]byte) bool) ([]byte, error) {
+	port, err := os.OpenFile(t.path, os.O_RDWR, 0)
+
+	if err != nil {
+		return nil, err
+	}
+	defer port.Close()
+
+	err = configureSerialPort(port, t.baudRate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return queryReadWriter(port, command, complete)
+}
+
+// Dry run: append everything that would have been printed to a file
+type filePrinterTransport struct {
+	path string
+}
+
+func (t *filePrinterTransport) Send(zpl string) error {
+	outFile, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	_, err = outFile.Write([]byte(zpl))
+	return err
+}
@@ -0,0 +1,175 @@
+package zplorama
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// A file as an LPD server received it
+type lpdFile struct {
+	subcommand byte
+	name       string
+	contents   string
+}
+
+// A print job as an LPD server received it
+type lpdJob struct {
+	queue string
+	files []lpdFile
+	err   error
+}
+
+// Take one print job over LPD, acknowledging each step with ack
+func serveLPDJob(listener net.Listener, ack byte) <-chan lpdJob {
+	jobs := make(chan lpdJob, 1)
+
+	go func() {
+		var job lpdJob
+		defer func() { jobs <- job }()
+
+		conn, err := listener.Accept()
+
+		if err != nil {
+			job.err = err
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		command, err := reader.ReadString('\n')
+
+		if err != nil || command[0] != '\x02' {
+			job.err = fmt.Errorf("bad receive job command %q (%v)", command, err)
+			return
+		}
+
+		job.queue = strings.TrimSuffix(command[1:], "\n")
+		conn.Write([]byte{ack})
+
+		for {
+			header, err := reader.ReadString('\n')
+
+			// The client hangs up once it's done
+			if err != nil {
+				if header != "" {
+					job.err = fmt.Errorf("truncated subcommand %q", header)
+				}
+				return
+			}
+
+			var size int
+			var name string
+
+			if _, err := fmt.Sscanf(header[1:], "%d %s\n", &size, &name); err != nil {
+				job.err = fmt.Errorf("bad subcommand %q (%v)", header, err)
+				return
+			}
+
+			conn.Write([]byte{ack})
+
+			// Each file is followed by a NUL
+			contents := make([]byte, size+1)
+
+			if _, err := io.ReadFull(reader, contents); err != nil || contents[size] != 0 {
+				job.err = fmt.Errorf("%v isn't %v bytes and a NUL (%v)", name, size, err)
+				return
+			}
+
+			job.files = append(job.files, lpdFile{subcommand: header[0], name: name, contents: string(contents[:size])})
+			conn.Write([]byte{ack})
+		}
+	}()
+
+	return jobs
+}
+
+func TestLPDSend(t *testing.T) {
+	cases := []struct {
+		queue string
+		zpl   string
+	}{
+		{"raw", "^XA^FO50,50^FDHello^FS^XZ"},
+		{"zebra", "^XA\n^FO50,50^FDTwo\nlines^FS\n^XZ\n"},
+		// Graphics can carry anything, NULs included
+		{"raw", "~DGR:LOGO.GRF,2,1,\xff\x00^XA^XGR:LOGO.GRF^FS^XZ"},
+	}
+
+	for _, test := range cases {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received := serveLPDJob(listener, 0)
+		transport := &lpdPrinterTransport{address: listener.Addr().String(), queue: test.queue}
+
+		err = transport.Send(test.zpl)
+		job := <-received
+		listener.Close()
+
+		if err != nil || job.err != nil {
+			t.Errorf("%q: got %v sending, %v receiving", test.zpl, err, job.err)
+			continue
+		}
+
+		if job.queue != test.queue {
+			t.Errorf("%q: got queue %q, want %q", test.zpl, job.queue, test.queue)
+		}
+
+		if len(job.files) != 2 || job.files[0].subcommand != '\x02' || job.files[1].subcommand != '\x03' {
+			t.Errorf("%q: got %+v, want a control file then a data file", test.zpl, job.files)
+			continue
+		}
+
+		control, data := job.files[0], job.files[1]
+		match := regexp.MustCompile(`^cfA(\d{3})(.{1,31})$`).FindStringSubmatch(control.name)
+
+		if match == nil || data.name != "dfA"+match[1]+match[2] {
+			t.Errorf("%q: got files %q and %q, want cfA###host and dfA###host", test.zpl, control.name, data.name)
+			continue
+		}
+
+		host := match[2]
+		wantControl := strings.Join([]string{
+			"H" + host,
+			"Pzplorama",
+			"l" + data.name,
+			"U" + data.name,
+			"Nlabel.zpl",
+		}, "\n") + "\n"
+
+		if control.contents != wantControl {
+			t.Errorf("%q: got control file %q, want %q", test.zpl, control.contents, wantControl)
+		}
+
+		if data.contents != test.zpl {
+			t.Errorf("%q: got data file %q", test.zpl, data.contents)
+		}
+	}
+}
+
+func TestLPDSendRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := serveLPDJob(listener, 1)
+	transport := &lpdPrinterTransport{address: listener.Addr().String(), queue: "nope"}
+
+	err = transport.Send("^XA^XZ")
+	<-received
+	want := "LPD server refused queue nope (code 1)"
+
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
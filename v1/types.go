@@ -1,25 +1,103 @@
 package zplorama
 
+import "github.com/jasonbot/zpl-o-rama/v1/zpl"
+
 const (
-	printjobTable = "print-jobs"
-	jobTimeTable  = "print-times"
+	printjobTable    = "print-jobs"
+	jobTimeTable     = "print-times"
+	queueTable       = "print-queue"
+	baselineTable    = "baselines"
+	framesTable      = "print-frames"
+	calibrationTable = "calibrations"
 )
 
 // ConfStruct is the configuration for the services
 type ConfStruct struct {
-	GoogleSite        string   `json:"google_site"`
-	AppSecret         string   `json:"app_secret"`
-	AuthCallback      string   `json:"auth_callback"`
-	FrontendPort      int      `json:"frontend_port"`
-	PrintserviceHost  string   `json:"printservice_host"`
-	PrintservicePort  int      `json:"printservice_port"`
-	PrintTime         string   `json:"print_time"`
-	PrintDial         string   `json:"print_dial"`
-	AuthtokenLifetime string   `json:"authtoken_lifetime"`
-	AuthSecret        string   `json:"authsecret"`
-	AllowedLogins     []string `json:"allowed_logins"`
-	BackendDatabase   string   `json:"backend_database"`
-	FrontenedDatabase string   `json:"frontend_database"`
+	GoogleSite           string                     `json:"google_site"`
+	AppSecret            string                     `json:"app_secret"`
+	AuthCallback         string                     `json:"auth_callback"`
+	FrontendPort         int                        `json:"frontend_port"`
+	PrintserviceHost     string                     `json:"printservice_host"`
+	PrintservicePort     int                        `json:"printservice_port"`
+	PrintTime            string                     `json:"print_time"`
+	PrintDial            string                     `json:"print_dial"`
+	PrintTransport       string                     `json:"print_transport"`
+	PrintLPDQueue        string                     `json:"print_lpd_queue"`
+	PrintBaudRate        int                        `json:"print_baud_rate"`
+	SkipStatusCheck      bool                       `json:"skip_status_check"`
+	StatusHoldTime       string                     `json:"status_hold_time"`
+	StatusPollInterval   string                     `json:"status_poll_interval"`
+	PrintSettleTime      string                     `json:"print_settle_time"`
+	RetryAttempts        int                        `json:"retry_attempts"`
+	RetryBackoff         string                     `json:"retry_backoff"`
+	RetryMaxBackoff      string                     `json:"retry_max_backoff"`
+	RetryInterruptedJobs bool                       `json:"retry_interrupted_jobs"`
+	AuthtokenLifetime    string                     `json:"authtoken_lifetime"`
+	AuthSecret           string                     `json:"authsecret"`
+	AllowedLogins        []string                   `json:"allowed_logins"`
+	BackendDatabase      string                     `json:"backend_database"`
+	FrontenedDatabase    string                     `json:"frontend_database"`
+	Printers             map[string]printerConfig   `json:"printers"`
+	DefaultPrinter       string                     `json:"default_printer"`
+	MediaProfiles        map[string]mediaProfile    `json:"media_profiles"`
+	PrinterInfoVars      []string                   `json:"printer_info_vars"`
+	Cameras              map[string]cameraConfig    `json:"cameras"`
+	Renditions           map[string]renditionConfig `json:"renditions"`
+	RenditionCacheMB     int                        `json:"rendition_cache_mb"`
+	SrcsetWidths         []int                      `json:"srcset_widths"`
+	LiveInterval         string                     `json:"live_interval"`
+	LintReject           string                     `json:"lint_reject"`
+}
+
+// printerConfig is one printer the print server drives
+type printerConfig struct {
+	Transport string   `json:"transport"`
+	Address   string   `json:"address"`
+	LPDQueue  string   `json:"lpd_queue"`
+	BaudRate  int      `json:"baud_rate"`
+	DPI       int      `json:"dpi"`
+	Media     string   `json:"media"`
+	Camera    string   `json:"camera"`
+	Cameras   []string `json:"cameras"`
+}
+
+// cameraConfig is a camera that can photograph a printer's output
+type cameraConfig struct {
+	Kind           string      `json:"kind"`
+	Device         string      `json:"device"`
+	Width          int         `json:"width"`
+	Height         int         `json:"height"`
+	Warmup         string      `json:"warmup"`
+	Timeout        string      `json:"timeout"`
+	Command        []string    `json:"command"`
+	StreamCommand  []string    `json:"stream_command"`
+	Persistent     bool        `json:"persistent"`
+	Corners        [][]float64 `json:"corners"`
+	AutoCrop       bool        `json:"auto_crop"`
+	BurstInterval  string      `json:"burst_interval"`
+	BurstMaxFrames int         `json:"burst_max_frames"`
+	URL            string      `json:"url"`
+	Directory      string      `json:"directory"`
+	File           string      `json:"file"`
+}
+
+// renditionConfig is a size and format to serve job pictures in
+type renditionConfig struct {
+	Width   int    `json:"width"`
+	Format  string `json:"format"`
+	Quality int    `json:"quality"`
+}
+
+// mediaProfile describes a kind of label stock and how to print on it
+type mediaProfile struct {
+	Width       float64 `json:"width"`
+	Length      float64 `json:"length"`
+	DPI         int     `json:"dpi"`
+	Darkness    int     `json:"darkness"`
+	PrintSpeed  int     `json:"print_speed"`
+	MediaType   string  `json:"media_type"`
+	Orientation string  `json:"orientation"`
+	Encoding    string  `json:"encoding"`
 }
 
 // Represents the state of the print job
@@ -31,30 +109,81 @@ const (
 	succeeded                = "SUCCEEDED"
 	failed                   = "FAILED"
 	missing                  = "MISSING"
+	cancelled                = "CANCELLED"
 )
 
 const emptyPNG string = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
 const sadFace string = "iVBORw0KGgoAAAANSUhEUgAAAAgAAAAICAYAAADED76LAAAAQElEQVQY04WPSwrAQAxCnyH3v/LrpoU0DIxLP6gBUOWAJCnVJB8xRVSLC+qt+CUn19N9mtI7uc09Bu0HqOR28wH8uiIQ3tOhaQAAAABJRU5ErkJggg=="
 
 type printJobRequest struct {
-	ZPL    string `json:"ZPL" form:"ZPL" query:"ZPL"`
-	Author string `json:"author"`
+	ZPL      string `json:"ZPL" form:"ZPL" query:"ZPL"`
+	Printer  string `json:"printer" form:"printer" query:"printer"`
+	Media    string `json:"media" form:"media" query:"media"`
+	Baseline string `json:"baseline" form:"baseline" query:"baseline"`
+	Burst    bool   `json:"burst" form:"burst" query:"burst"`
+	// Print and photograph each label in the ZPL as a job of its own
+	Split bool `json:"split" form:"split" query:"split"`
+	// Set on the jobs that print the calibration pattern
+	Calibrate bool   `json:"calibrate"`
+	Author    string `json:"author"`
 	// NOT PUBLIC -- assigned by the software at execution time
 	jobid string
+	// The job this one is a label of, and which label it is
+	parent string
+	label  int
+}
+
+// The picture one of the printer's cameras took of a job
+type jobImage struct {
+	Camera         string `json:"camera"`
+	ImageB64       string `json:"image"`
+	ImageB64Small  string `json:"image_small"`
+	ImageB64Raw    string `json:"image_raw"`
+	CaptureStarted string `json:"capture_started"`
+	CaptureMillis  int64  `json:"capture_ms"`
+	// Why the camera didn't take a picture, in which case the image is a placeholder
+	Error string `json:"error,omitempty"`
 }
 
 type printJobStatus struct {
-	Jobid         string        `json:"jobid"`
-	Status        pictureStatus `json:"status"`
-	ZPL           string        `json:"ZPL"`
-	ImageB64      string        `json:"image"`
-	ImageB64Small string        `json:"image_small"`
-	Created       string        `json:"created"`
-	Updated       string        `json:"updated"`
-	Author        string        `json:"author"`
-	Message       string        `json:"message"`
-	Log           []string      `json:"log"`
-	Done          bool          `json:"done"`
+	Jobid           string            `json:"jobid"`
+	Printer         string            `json:"printer"`
+	Media           string            `json:"media"`
+	PrinterModel    string            `json:"printer_model"`
+	PrinterFirmware string            `json:"printer_firmware"`
+	Status          pictureStatus     `json:"status"`
+	ZPL             string            `json:"ZPL"`
+	Lint            []zpl.Diagnostic  `json:"lint"`
+	Images          []jobImage        `json:"images"`
+	Rendered        bool              `json:"rendered"`
+	Parent          string            `json:"parent"`
+	Label           int               `json:"label"`
+	Subjobs         []subjobResult    `json:"subjobs"`
+	Barcodes        []barcodeCheck    `json:"barcodes"`
+	BaselineName    string            `json:"baseline_name"`
+	ComparedTo      string            `json:"compared_to"`
+	Similarity      float64           `json:"similarity"`
+	Frames          []int64           `json:"frames"`
+	Calibration     string            `json:"calibration"`
+	ImageWidth      int               `json:"image_width"`
+	ImageHeight     int               `json:"image_height"`
+	FieldOrigins    []fieldOrigin     `json:"field_origins"`
+	Measurement     *labelMeasurement `json:"measurement"`
+	Created         string            `json:"created"`
+	Updated         string            `json:"updated"`
+	Author          string            `json:"author"`
+	Message         string            `json:"message"`
+	Log             []string          `json:"log"`
+	Done            bool              `json:"done"`
+}
+
+// How one label of a split job is getting on
+type subjobResult struct {
+	Jobid   string        `json:"jobid"`
+	Label   int           `json:"label"`
+	Status  pictureStatus `json:"status"`
+	Message string        `json:"message"`
+	Done    bool          `json:"done"`
 }
 
 // Make this struct boltable
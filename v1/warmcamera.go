@@ -0,0 +1,312 @@
+package zplorama
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Keeps MJPEG flowing from the camera; {{.Device}}, {{.Width}} and {{.Height}} are filled in
+var defaultFFmpegStreamCommand = []string{
+	"ffmpeg", "-loglevel", "error",
+	"-f", "v4l2", "-video_size", "{{.Width}}x{{.Height}}", "-i", "{{.Device}}",
+	"-f", "mjpeg", "-q:v", "3", "-",
+}
+
+var (
+	jpegStart = []byte{0xff, 0xd8}
+	jpegEnd   = []byte{0xff, 0xd9}
+)
+
+// WarmCamera is a camera that stays running between pictures
+type WarmCamera interface {
+	Camera
+	Start() error
+}
+
+func newWarmCamera(config cameraConfig, warmup, timeout time.Duration) (Camera, error) {
+	switch config.Kind {
+	case raspistillCamera, "":
+		return newSignalCamera("raspistill", func(output string) []string {
+			return append([]string{"-s", "-t", "0", "-e", "png", "-o", output}, raspistillArgs(config)...)
+		}, warmup, timeout)
+	case libcameraCamera:
+		return newSignalCamera("libcamera-still", func(output string) []string {
+			return append([]string{"-n", "--signal", "-t", "0", "-e", "png", "-o", output}, libcameraArgs(config)...)
+		}, warmup, timeout)
+	case v4l2Camera:
+		return &streamCamera{command: "v4l2-ctl", args: v4l2Args(config), warmup: warmup, timeout: timeout}, nil
+	case ffmpegCamera:
+		command, err := expandCommandTemplate(config.StreamCommand, defaultFFmpegStreamCommand, config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &streamCamera{command: command[0], args: command[1:], warmup: warmup, timeout: timeout}, nil
+	}
+
+	return nil, fmt.Errorf("Camera kind %v can't be kept running", config.Kind)
+}
+
+// A Pi camera program left running in signal mode, which writes a new
+// picture into a scratch directory every time it gets a signal
+type signalCamera struct {
+	command   string
+	args      []string
+	directory string
+	warmup    time.Duration
+	timeout   time.Duration
+
+	lock    sync.Mutex
+	process *exec.Cmd
+	started time.Time
+	exited  chan struct{}
+}
+
+func newSignalCamera(command string, args func(string) []string, warmup, timeout time.Duration) (Camera, error) {
+	directory, err := os.MkdirTemp("", "zplorama-camera-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &signalCamera{
+		command:   command,
+		args:      args(filepath.Join(directory, "frame%04d.png")),
+		directory: directory,
+		warmup:    warmup,
+		timeout:   timeout,
+	}, nil
+}
+
+func (camera *signalCamera) running() bool {
+	if camera.process == nil {
+		return false
+	}
+
+	select {
+	case <-camera.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Call with the lock held
+func (camera *signalCamera) ensureRunning() error {
+	if camera.running() {
+		return nil
+	}
+
+	process := exec.Command(camera.command, camera.args...)
+	err := process.Start()
+
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		err := process.Wait()
+		log.Printf("%v exited: %v", camera.command, err)
+		close(exited)
+	}()
+
+	camera.process = process
+	camera.exited = exited
+	camera.started = time.Now()
+
+	return nil
+}
+
+func (camera *signalCamera) Start() error {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	return camera.ensureRunning()
+}
+
+func (camera *signalCamera) Capture() ([]byte, error) {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	err := camera.ensureRunning()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The program dies if it's signalled before it's ready for it
+	if warming := camera.warmup - time.Since(camera.started); warming > 0 {
+		time.Sleep(warming)
+	}
+
+	requested := time.Now()
+	err = signalCapture(camera.process.Process)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := waitForNewFile(camera.directory, requested, camera.timeout)
+
+	// Don't let old frames pile up
+	if entries, readErr := os.ReadDir(camera.directory); readErr == nil {
+		for _, entry := range entries {
+			os.Remove(filepath.Join(camera.directory, entry.Name()))
+		}
+	}
+
+	return data, err
+}
+
+// A capture program left streaming MJPEG to stdout; a picture is just the
+// first whole frame to arrive after it was asked for
+type streamCamera struct {
+	command string
+	args    []string
+	warmup  time.Duration
+	timeout time.Duration
+
+	lock      sync.Mutex
+	startOnce sync.Once
+	frame     []byte
+	frameTime time.Time
+	newFrame  chan struct{}
+	// When the capture program last (re)started
+	streamStarted time.Time
+}
+
+func (camera *streamCamera) Start() error {
+	camera.startOnce.Do(func() {
+		camera.newFrame = make(chan struct{})
+		go camera.keepStreaming()
+	})
+
+	return nil
+}
+
+// Restart the capture program whenever it falls over
+func (camera *streamCamera) keepStreaming() {
+	for {
+		err := camera.stream()
+		log.Printf("%v stopped streaming: %v", camera.command, err)
+
+		time.Sleep(time.Second)
+	}
+}
+
+func (camera *streamCamera) stream() error {
+	process := exec.Command(camera.command, camera.args...)
+	stdout, err := process.StdoutPipe()
+
+	if err != nil {
+		return err
+	}
+
+	err = process.Start()
+
+	if err != nil {
+		return err
+	}
+	defer process.Wait()
+	defer process.Process.Kill()
+
+	camera.lock.Lock()
+	camera.streamStarted = time.Now()
+	camera.lock.Unlock()
+
+	return splitJPEGStream(stdout, camera.setFrame)
+}
+
+// Call frame with each whole JPEG in an MJPEG stream
+func splitJPEGStream(reader io.Reader, frame func([]byte)) error {
+	pending := make([]byte, 0)
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := reader.Read(buf)
+		pending = append(pending, buf[:n]...)
+
+		for {
+			start := bytes.Index(pending, jpegStart)
+
+			if start == -1 {
+				pending = pending[:0]
+				break
+			}
+
+			end := bytes.Index(pending[start+len(jpegStart):], jpegEnd)
+
+			if end == -1 {
+				pending = pending[start:]
+				break
+			}
+
+			end += start + len(jpegStart) + len(jpegEnd)
+			frame(append([]byte(nil), pending[start:end]...))
+			pending = pending[end:]
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (camera *streamCamera) setFrame(frame []byte) {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	camera.frame = frame
+	camera.frameTime = time.Now()
+
+	close(camera.newFrame)
+	camera.newFrame = make(chan struct{})
+}
+
+// The newest frame and when it arrived, along with a channel that closes when the next one does
+func (camera *streamCamera) latestFrame() ([]byte, time.Time, chan struct{}) {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	return camera.frame, camera.frameTime, camera.newFrame
+}
+
+// When frames from the running capture program can be trusted, once its
+// exposure has had the warmup to settle
+func (camera *streamCamera) warmedUp() time.Time {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	return camera.streamStarted.Add(camera.warmup)
+}
+
+func (camera *streamCamera) Capture() ([]byte, error) {
+	camera.Start()
+
+	requested := time.Now()
+	giveUp := time.After(camera.timeout)
+
+	for {
+		frame, frameTime, next := camera.latestFrame()
+
+		if frame != nil && frameTime.After(requested) && frameTime.After(camera.warmedUp()) {
+			return normalizeImage(frame)
+		}
+
+		select {
+		case <-next:
+		case <-giveUp:
+			return nil, errors.New("Camera stream did not deliver a frame in time")
+		}
+	}
+}
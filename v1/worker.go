@@ -0,0 +1,255 @@
+package zplorama
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/labstack/echo"
+)
+
+// What a printer's worker goroutine is up to
+type workerStatus string
+
+const (
+	workerIdle       workerStatus = "IDLE"
+	workerPrinting                = "PRINTING"
+	workerRetrying                = "RETRYING"
+	workerCapturing               = "CAPTURING"
+	workerRestarting              = "RESTARTING"
+)
+
+type workerState struct {
+	State         workerStatus `json:"state"`
+	CurrentJob    string       `json:"current_job"`
+	LastError     string       `json:"last_error"`
+	LastErrorTime string       `json:"last_error_time"`
+	JobsProcessed int          `json:"jobs_processed"`
+	JobsFailed    int          `json:"jobs_failed"`
+	Restarts      int          `json:"restarts"`
+	Queued        int          `json:"queued"`
+}
+
+const cancelAllCommand = "~JA"
+
+var errJobCancelled = errors.New("Job cancelled")
+
+// Jobs someone has asked to stop
+type jobCancellations struct {
+	lock sync.Mutex
+	jobs map[string]bool
+}
+
+// Ask for a job to stop, returning whether it's the one the worker is on
+func (worker *printerWorker) requestCancel(jobID string) bool {
+	worker.cancels.lock.Lock()
+	defer worker.cancels.lock.Unlock()
+
+	if worker.cancels.jobs == nil {
+		worker.cancels.jobs = make(map[string]bool)
+	}
+
+	worker.cancels.jobs[jobID] = true
+
+	return worker.currentState().CurrentJob == jobID
+}
+
+func (worker *printerWorker) cancelRequested(jobID string) bool {
+	worker.cancels.lock.Lock()
+	defer worker.cancels.lock.Unlock()
+
+	return worker.cancels.jobs[jobID]
+}
+
+func (worker *printerWorker) clearCancel(jobID string) {
+	worker.cancels.lock.Lock()
+	defer worker.cancels.lock.Unlock()
+
+	delete(worker.cancels.jobs, jobID)
+}
+
+// Drop the cancel request for a job taken out of the queue, unless the
+// worker picked it up first, in which case processJob clears it
+func (worker *printerWorker) forgetCancel(jobID string) {
+	worker.cancels.lock.Lock()
+	defer worker.cancels.lock.Unlock()
+
+	if worker.currentState().CurrentJob != jobID {
+		delete(worker.cancels.jobs, jobID)
+	}
+}
+
+// Sleep, but wake up early if the job gets cancelled
+func (worker *printerWorker) sleepUnlessCancelled(jobID string, duration time.Duration) {
+	giveUp := time.Now().Add(duration)
+
+	for time.Now().Before(giveUp) && !worker.cancelRequested(jobID) {
+		nap := time.Until(giveUp)
+		if nap > 100*time.Millisecond {
+			nap = 100 * time.Millisecond
+		}
+
+		time.Sleep(nap)
+	}
+}
+
+type workerStateTracker struct {
+	lock  sync.Mutex
+	state workerState
+}
+
+func (worker *printerWorker) setState(state workerStatus, err error) {
+	worker.tracker.lock.Lock()
+	defer worker.tracker.lock.Unlock()
+
+	worker.tracker.state.State = state
+
+	if err != nil {
+		worker.tracker.state.LastError = err.Error()
+		worker.tracker.state.LastErrorTime = time.Now().Format(time.RFC3339)
+	}
+}
+
+func (worker *printerWorker) beginJob(jobID string) {
+	worker.tracker.lock.Lock()
+	defer worker.tracker.lock.Unlock()
+
+	worker.tracker.state.State = workerPrinting
+	worker.tracker.state.CurrentJob = jobID
+}
+
+func (worker *printerWorker) finishJob(status pictureStatus, message string) {
+	worker.tracker.lock.Lock()
+	defer worker.tracker.lock.Unlock()
+
+	worker.tracker.state.State = workerIdle
+	worker.tracker.state.CurrentJob = ""
+	worker.tracker.state.JobsProcessed++
+
+	if status == failed {
+		worker.tracker.state.JobsFailed++
+		worker.tracker.state.LastError = message
+		worker.tracker.state.LastErrorTime = time.Now().Format(time.RFC3339)
+	}
+}
+
+func (worker *printerWorker) currentState() workerState {
+	worker.tracker.lock.Lock()
+	defer worker.tracker.lock.Unlock()
+
+	return worker.tracker.state
+}
+
+// Let the worker know there's something new in the queue
+func (worker *printerWorker) notify() {
+	select {
+	case worker.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Process queued jobs until told to stop, turning a panic into an error
+func (worker *printerWorker) runJobs(db *bolt.DB) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	for {
+		job, err := nextQueuedJob(db, worker.name)
+
+		if err != nil {
+			return err
+		} else if job == nil {
+			// Closing wake tells the worker to stop once the queue is empty
+			if _, ok := <-worker.wake; !ok {
+				return nil
+			}
+			continue
+		}
+
+		worker.beginJob(job.Jobid)
+		worker.processJob(db, job.request())
+
+		err = removeQueuedJob(db, job.Jobid)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Keep the printer's worker alive: if it crashes, fail the job it was on and start it back up
+func (worker *printerWorker) supervise(db *bolt.DB) {
+	backoff := parseDurationOr(Config.RetryBackoff, 1*time.Second)
+
+	worker.setState(workerIdle, nil)
+
+	for {
+		err := worker.runJobs(db)
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("Worker for printer %v crashed: %v", worker.name, err)
+
+		state := worker.currentState()
+
+		if state.CurrentJob != "" {
+			failJob(db, state.CurrentJob, fmt.Sprintf("Print worker crashed: %v", err))
+			removeQueuedJob(db, state.CurrentJob)
+		}
+
+		worker.tracker.lock.Lock()
+		worker.tracker.state.State = workerRestarting
+		worker.tracker.state.CurrentJob = ""
+		worker.tracker.state.JobsProcessed++
+		worker.tracker.state.JobsFailed++
+		worker.tracker.state.Restarts++
+		worker.tracker.state.LastError = err.Error()
+		worker.tracker.state.LastErrorTime = time.Now().Format(time.RFC3339)
+		worker.tracker.lock.Unlock()
+
+		time.Sleep(backoff)
+
+		worker.setState(workerIdle, nil)
+	}
+}
+
+func failJob(db *bolt.DB, jobID string, message string) {
+	jobRecordLock.Lock()
+	defer jobRecordLock.Unlock()
+
+	status := printJobStatus{Jobid: jobID}
+
+	if GetRecord(db, &status) != nil || status.Done {
+		return
+	}
+
+	status.Status = failed
+	status.Message = message
+	status.Done = true
+
+	updateJob(db, &status)
+}
+
+func getWorkerState(database *bolt.DB, workers map[string]*printerWorker) func(echo.Context) error {
+	return func(c echo.Context) error {
+		worker, ok := workers[c.Param("name")]
+
+		if !ok {
+			return c.JSON(http.StatusNotFound, errJSON{Errmsg: "Printer not found"})
+		}
+
+		state := worker.currentState()
+		state.Queued = countQueuedJobs(database, worker.name)
+
+		return c.JSON(http.StatusOK, state)
+	}
+}
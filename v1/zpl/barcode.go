@@ -0,0 +1,303 @@
+package zpl
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/datamatrix/encoder"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// QRCodeData splits ^BQ field data (^FDQA,text) into its error correction
+// level and the text the symbol holds
+func QRCodeData(data string) (byte, string) {
+	errorCorrection := byte('Q')
+	comma := strings.Index(data, ",")
+
+	if comma < 0 {
+		return errorCorrection, data
+	}
+
+	mode := ""
+	if comma >= 1 {
+		errorCorrection = strings.ToUpper(data[:1])[0]
+	}
+	if comma >= 2 {
+		mode = strings.ToUpper(data[1:2])
+	}
+
+	data = data[comma+1:]
+
+	// Manual mode puts the character mode before the data, and a byte count for binary
+	if mode == "M" && len(data) > 0 {
+		if strings.ToUpper(data[:1]) == "B" && len(data) >= 5 {
+			data = data[5:]
+		} else {
+			data = data[1:]
+		}
+	}
+
+	return errorCorrection, data
+}
+
+// Code128Text is what Code 128 field data encodes, without the > escapes
+// that pick subsets and function codes
+func Code128Text(data string) string {
+	// Subset and function codes don't scan as anything
+	for _, invocation := range []string{">:", ">;", ">9", ">5", ">6", ">7", ">8"} {
+		data = strings.ReplaceAll(data, invocation, "")
+	}
+
+	return strings.ReplaceAll(data, "><", ">")
+}
+
+// Fit digits to the length a symbology takes the way a printer does:
+// padded with leading zeros or cut short
+func fitDigits(data string, digits int) string {
+	if len(data) > digits {
+		return data[:digits]
+	}
+
+	return strings.Repeat("0", digits-len(data)) + data
+}
+
+// The EAN/UPC check digit for some digits
+func upcCheckDigit(digits string) string {
+	sum := 0
+
+	for index := 0; index < len(digits); index++ {
+		digit := int(digits[len(digits)-1-index] - '0')
+
+		if index%2 == 0 {
+			sum += 3 * digit
+		} else {
+			sum += digit
+		}
+	}
+
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+// Writers for the linear symbologies the preview draws
+var linearSymbologies = map[string]struct {
+	format gozxing.BarcodeFormat
+	writer func() gozxing.Writer
+}{
+	"B2": {gozxing.BarcodeFormat_ITF, oned.NewITFWriter},
+	"B3": {gozxing.BarcodeFormat_CODE_39, oned.NewCode39Writer},
+	"B8": {gozxing.BarcodeFormat_EAN_8, oned.NewEAN8Writer},
+	"B9": {gozxing.BarcodeFormat_UPC_E, oned.NewUPCEWriter},
+	"BA": {gozxing.BarcodeFormat_CODE_93, oned.NewCode93Writer},
+	"BC": {gozxing.BarcodeFormat_CODE_128, oned.NewCode128Writer},
+	"BE": {gozxing.BarcodeFormat_EAN_13, oned.NewEAN13Writer},
+	"BK": {gozxing.BarcodeFormat_CODABAR, oned.NewCodaBarWriter},
+	"BU": {gozxing.BarcodeFormat_UPC_A, oned.NewUPCAWriter},
+}
+
+// Which of a linear barcode's parameters are its height and interpretation line settings
+type barcodeParams struct {
+	height, line, above int
+	// Whether the interpretation line prints unless asked not to
+	lineDefault bool
+}
+
+var linearParams = map[string]barcodeParams{
+	"B2": {1, 2, 3, false},
+	"B3": {2, 3, 4, true},
+	"B8": {1, 2, 3, true},
+	"B9": {1, 2, 3, true},
+	"BA": {1, 2, 3, true},
+	"BC": {1, 2, 3, true},
+	"BE": {1, 2, 3, true},
+	"BK": {2, 3, 4, true},
+	"BU": {1, 2, 3, true},
+}
+
+// ^BY: module width and bar height
+type barcodeDefaults struct {
+	module int
+	height int
+}
+
+var defaultBarcode = barcodeDefaults{module: 2, height: 10}
+
+// What a linear barcode encodes, and what its interpretation line says
+func linearText(name string, data string) (string, string) {
+	switch name {
+	case "BC":
+		data = Code128Text(data)
+		return data, data
+	case "B3":
+		return data, "*" + data + "*"
+	case "B2":
+		// Interleaved 2 of 5 digits go in pairs
+		if len(data)%2 != 0 {
+			data = "0" + data
+		}
+		return data, data
+	case "BE", "B8", "BU":
+		data = fitDigits(data, symbologyDigits[name])
+		data += upcCheckDigit(data)
+		return data, data
+	}
+
+	return data, data
+}
+
+// Draw a linear barcode: bars module dots wide, with its interpretation
+// line if it has one, cut down to fit within limit either way round.
+// Returns the ink and how far down the bottom of the bars is.
+func drawLinear(barcode *Command, data string, defaults barcodeDefaults, limit image.Point) (*image.Alpha, int, error) {
+	symbology := linearSymbologies[barcode.Name]
+	params := linearParams[barcode.Name]
+	text, line := linearText(barcode.Name, data)
+
+	hints := map[gozxing.EncodeHintType]interface{}{gozxing.EncodeHintType_MARGIN: 0}
+	matrix, err := symbology.writer().Encode(text, symbology.format, 0, 0, hints)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	height, _ := barcode.Int(params.height, defaults.height)
+	height = clamp(height, 1, limit.X)
+	showLine := yes(barcode.Arg(params.line), params.lineDefault)
+	above := yes(barcode.Arg(params.above), false)
+
+	var lineMask *image.Alpha
+	lineHeight := 0
+
+	if showLine {
+		spec := newFontSpec('0', 9*defaults.module, 5*defaults.module)
+		lineMask, _ = spec.draw(line, limit.Y)
+		lineHeight = spec.height + defaults.module
+	}
+
+	modules := matrix.GetWidth()
+	width := clamp(modules*defaults.module, 0, limit.Y)
+	if lineMask != nil && lineMask.Bounds().Dx() > width {
+		width = lineMask.Bounds().Dx()
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, height+lineHeight))
+	barsTop := 0
+	if above {
+		barsTop = lineHeight
+	}
+
+	for module := 0; module < modules; module++ {
+		if !matrix.Get(module, 0) {
+			continue
+		}
+
+		if module*defaults.module >= width {
+			break
+		}
+
+		for y := barsTop; y < barsTop+height; y++ {
+			for x := module * defaults.module; x < (module+1)*defaults.module && x < width; x++ {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+
+	if lineMask != nil {
+		lineTop := height + defaults.module
+		if above {
+			lineTop = 0
+		}
+
+		overlay(mask, lineMask, (modules*defaults.module-lineMask.Bounds().Dx())/2, lineTop)
+	}
+
+	return mask, barsTop + height, nil
+}
+
+// Draw a QR code or Data Matrix symbol, each module a square of dots, with
+// the modules made smaller if it wouldn't fit within limit
+func drawMatrix(barcode *Command, data string, defaults barcodeDefaults, dpi int, limit image.Point) (*image.Alpha, error) {
+	var matrix *gozxing.BitMatrix
+	var err error
+	var module int
+
+	switch barcode.Name {
+	case "BQ":
+		errorCorrection, text := QRCodeData(data)
+
+		// Magnification defaults to what reads well at the printer's resolution
+		magnification := 10
+		switch {
+		case dpi <= 150:
+			magnification = 1
+		case dpi <= 200:
+			magnification = 2
+		case dpi <= 300:
+			magnification = 3
+		case dpi <= 600:
+			magnification = 6
+		}
+		module, _ = barcode.Int(2, magnification)
+
+		hints := map[gozxing.EncodeHintType]interface{}{
+			gozxing.EncodeHintType_MARGIN:           0,
+			gozxing.EncodeHintType_ERROR_CORRECTION: string(errorCorrection),
+		}
+		matrix, err = qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 0, 0, hints)
+	case "BX":
+		module, _ = barcode.Int(1, defaults.module)
+		// Printers make square symbols unless asked for columns and rows
+		hints := map[gozxing.EncodeHintType]interface{}{
+			gozxing.EncodeHintType_DATA_MATRIX_SHAPE: encoder.SymbolShapeHint_FORCE_SQUARE,
+		}
+		matrix, err = datamatrix.NewDataMatrixWriter().Encode(data, gozxing.BarcodeFormat_DATA_MATRIX, 0, 0, hints)
+	default:
+		return nil, fmt.Errorf("No way to draw ^%v", barcode.Name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	side := matrix.GetWidth()
+	if matrix.GetHeight() > side {
+		side = matrix.GetHeight()
+	}
+
+	room := limit.X
+	if limit.Y < room {
+		room = limit.Y
+	}
+
+	biggest := room / side
+	if biggest < 1 {
+		biggest = 1
+	}
+
+	module = clamp(module, 1, biggest)
+
+	mask := image.NewAlpha(image.Rect(0, 0, matrix.GetWidth()*module, matrix.GetHeight()*module))
+
+	for y := 0; y < mask.Bounds().Dy(); y++ {
+		for x := 0; x < mask.Bounds().Dx(); x++ {
+			if matrix.Get(x/module, y/module) {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+// A Y/N parameter, or fallback if it wasn't given
+func yes(arg string, fallback bool) bool {
+	if arg == "" {
+		return fallback
+	}
+
+	return strings.ToUpper(arg) == "Y"
+}
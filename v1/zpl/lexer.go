@@ -0,0 +1,234 @@
+// Package zpl reads ZPL II the way a Zebra printer does: it splits a stream
+// into commands (following ^CC/^CT/^CD prefix and delimiter changes), groups
+// them into labels and fields, and remembers where in the source everything
+// came from so problems can be pointed at.
+package zpl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Default command prefixes and parameter delimiter
+const (
+	DefaultFormatPrefix  = '^'
+	DefaultControlPrefix = '~'
+	DefaultDelimiter     = ','
+)
+
+// Position is a place in ZPL source: a byte offset, and a 1-based line and
+// column (in characters) for people
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (position Position) String() string {
+	return fmt.Sprintf("%v:%v", position.Line, position.Column)
+}
+
+// TokenKind tells commands apart from stray text between them
+type TokenKind int
+
+const (
+	// CommandToken is a ^ or ~ command with its parameters
+	CommandToken TokenKind = iota
+	// TextToken is anything that isn't part of a command; printers ignore it
+	TextToken
+)
+
+// Token is one command (or run of stray text) in ZPL source
+type Token struct {
+	Kind TokenKind
+	// The prefix as written, which may not be ^ or ~ after a ^CC or ^CT
+	Prefix byte
+	// Control commands (~ by default) act immediately rather than as part of a label
+	Control bool
+	// Command name upper-cased and without its prefix: "FO", "BC", "A" for
+	// font commands like ^A0N
+	Name string
+	// Everything after the name up to the next command, exactly as written;
+	// the whole text for a TextToken
+	Params string
+	// Where the prefix (or text) starts, where the parameters start and
+	// just past the end
+	Pos       Position
+	ParamsPos Position
+	End       Position
+}
+
+func (token Token) String() string {
+	if token.Kind == TextToken {
+		return token.Params
+	}
+
+	return string(token.Prefix) + token.Name + token.Params
+}
+
+// Commands whose parameters run until the next format command, so they can
+// hold ~ and any other character
+var dataCommands = map[string]bool{
+	"FD": true,
+	"FV": true,
+	"FX": true,
+}
+
+// Commands that change a prefix or the delimiter
+var prefixCommands = map[string]bool{
+	"CC": true,
+	"CT": true,
+	"CD": true,
+}
+
+// Lexer splits ZPL source into tokens
+type Lexer struct {
+	source        string
+	offset        int
+	lineStarts    []int
+	formatPrefix  byte
+	controlPrefix byte
+}
+
+// NewLexer starts reading source with the default prefixes
+func NewLexer(source string) *Lexer {
+	lexer := &Lexer{
+		source:        source,
+		lineStarts:    []int{0},
+		formatPrefix:  DefaultFormatPrefix,
+		controlPrefix: DefaultControlPrefix,
+	}
+
+	for index := 0; index < len(source); index++ {
+		if source[index] == '\n' {
+			lexer.lineStarts = append(lexer.lineStarts, index+1)
+		}
+	}
+
+	return lexer
+}
+
+// Position of a byte offset in the source
+func (lexer *Lexer) Position(offset int) Position {
+	line := sort.Search(len(lexer.lineStarts), func(index int) bool {
+		return lexer.lineStarts[index] > offset
+	}) - 1
+
+	return Position{
+		Offset: offset,
+		Line:   line + 1,
+		Column: utf8.RuneCountInString(lexer.source[lexer.lineStarts[line]:offset]) + 1,
+	}
+}
+
+func (lexer *Lexer) isPrefix(char byte) bool {
+	return char == lexer.formatPrefix || char == lexer.controlPrefix
+}
+
+// Next returns the next token, or false at the end of the source. Stray
+// text that is only whitespace is skipped.
+func (lexer *Lexer) Next() (Token, bool) {
+	for lexer.offset < len(lexer.source) {
+		start := lexer.offset
+
+		if !lexer.isPrefix(lexer.source[start]) {
+			end := start
+			for end < len(lexer.source) && !lexer.isPrefix(lexer.source[end]) {
+				end++
+			}
+			lexer.offset = end
+
+			if strings.TrimSpace(lexer.source[start:end]) == "" {
+				continue
+			}
+
+			return Token{
+				Kind:      TextToken,
+				Params:    lexer.source[start:end],
+				Pos:       lexer.Position(start),
+				ParamsPos: lexer.Position(start),
+				End:       lexer.Position(end),
+			}, true
+		}
+
+		return lexer.command(start), true
+	}
+
+	return Token{}, false
+}
+
+func (lexer *Lexer) command(start int) Token {
+	prefix := lexer.source[start]
+	control := prefix == lexer.controlPrefix && prefix != lexer.formatPrefix
+
+	nameEnd := start + 1
+	for nameEnd < len(lexer.source) && nameEnd < start+3 && !lexer.isPrefix(lexer.source[nameEnd]) {
+		nameEnd++
+	}
+	name := strings.ToUpper(lexer.source[start+1 : nameEnd])
+
+	// ^A picks a font by the character after it, so ^A0N,30 is ^A with 0N,30
+	// (^A@ is a command of its own)
+	if len(name) == 2 && name[0] == 'A' && name[1] != '@' {
+		name = "A"
+		nameEnd = start + 2
+	}
+
+	end := nameEnd
+
+	// Prefix and delimiter changes take just the one character, which may be
+	// the new prefix, and take effect straight away
+	if prefixCommands[name] && end < len(lexer.source) {
+		switch name {
+		case "CC":
+			lexer.formatPrefix = lexer.source[end]
+		case "CT":
+			lexer.controlPrefix = lexer.source[end]
+		}
+
+		end++
+	}
+
+	for end < len(lexer.source) {
+		char := lexer.source[end]
+
+		if char == lexer.formatPrefix || (char == lexer.controlPrefix && !dataCommands[name]) {
+			break
+		}
+
+		end++
+	}
+
+	token := Token{
+		Kind:      CommandToken,
+		Prefix:    prefix,
+		Control:   control,
+		Name:      name,
+		Params:    lexer.source[nameEnd:end],
+		Pos:       lexer.Position(start),
+		ParamsPos: lexer.Position(nameEnd),
+		End:       lexer.Position(end),
+	}
+
+	lexer.offset = end
+
+	return token
+}
+
+// Tokenize splits all of source into tokens
+func Tokenize(source string) []Token {
+	lexer := NewLexer(source)
+	var tokens []Token
+
+	for {
+		token, ok := lexer.Next()
+
+		if !ok {
+			return tokens
+		}
+
+		tokens = append(tokens, token)
+	}
+}
@@ -0,0 +1,109 @@
+package zpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func lexAll(source string) []string {
+	lexer := NewLexer(source)
+	var tokens []string
+
+	for {
+		token, ok := lexer.Next()
+
+		if !ok {
+			return tokens
+		}
+
+		if token.Kind == TextToken {
+			tokens = append(tokens, "text "+token.Params)
+		} else if token.Control {
+			tokens = append(tokens, "control "+token.String())
+		} else {
+			tokens = append(tokens, "format "+token.String())
+		}
+	}
+}
+
+func TestLexerPrefixChanges(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			"defaults",
+			"^XA^FO10,20^FDhi^FS~JA^XZ",
+			[]string{"format ^XA", "format ^FO10,20", "format ^FDhi", "format ^FS", "control ~JA", "format ^XZ"},
+		},
+		{
+			"^CC changes the format prefix straight away",
+			"^CC!!XA!FO1,2^FS!XZ",
+			[]string{"format ^CC!", "format !XA", "format !FO1,2^FS", "format !XZ"},
+		},
+		{
+			"~CC changes the format prefix too",
+			"~CC!!XA!XZ",
+			[]string{"control ~CC!", "format !XA", "format !XZ"},
+		},
+		{
+			"~CT changes the control prefix",
+			"~CT+^XA+JA~JA^XZ",
+			[]string{"control ~CT+", "format ^XA", "control +JA~JA", "format ^XZ"},
+		},
+		{
+			"a prefix can be changed to the other one's character",
+			"^CC~~XA~XZ",
+			[]string{"format ^CC~", "format ~XA", "format ~XZ"},
+		},
+		{
+			"field data runs over control prefixes",
+			"^XA^FDa~b^FS^XZ",
+			[]string{"format ^XA", "format ^FDa~b", "format ^FS", "format ^XZ"},
+		},
+		{
+			"font commands take one character of name",
+			"^A0N,30^A@N,20,20",
+			[]string{"format ^A0N,30", "format ^A@N,20,20"},
+		},
+		{
+			"stray text",
+			"junk ^XA\n\n^XZ",
+			[]string{"text junk ", "format ^XA\n\n", "format ^XZ"},
+		},
+		{
+			"prefix change at the very end",
+			"^XA^CC",
+			[]string{"format ^XA", "format ^CC"},
+		},
+		{
+			"lone prefixes",
+			"^~^",
+			[]string{"format ^", "control ~", "format ^"},
+		},
+	}
+
+	for _, test := range cases {
+		if got := lexAll(test.source); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: lexing %q got %q, want %q", test.name, test.source, got, test.want)
+		}
+	}
+}
+
+func TestLexerPositions(t *testing.T) {
+	lexer := NewLexer("^XA\n  ^FOé,1\n^XZ")
+	want := []Position{{0, 1, 1}, {6, 2, 3}, {14, 3, 1}}
+
+	for _, position := range want {
+		token, ok := lexer.Next()
+
+		if !ok {
+			t.Fatalf("ran out of tokens before %v", position)
+		}
+
+		if token.Pos != position {
+			t.Errorf("%v is at %+v, want %+v", token, token.Pos, position)
+		}
+	}
+}
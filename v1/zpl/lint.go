@@ -0,0 +1,555 @@
+package zpl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity is how much a diagnostic matters: errors mean the label won't
+// come out the way it was written, warnings that it might not
+type Severity string
+
+// Diagnostic severities
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Diagnostic is one problem the linter found, and where
+type Diagnostic struct {
+	Position
+	Severity Severity `json:"severity"`
+	// The command it's about as written (^FO, ~JA), if any
+	Command string `json:"command,omitempty"`
+	Message string `json:"message"`
+}
+
+func (diagnostic Diagnostic) String() string {
+	return fmt.Sprintf("%v: %v: %v", diagnostic.Position, diagnostic.Severity, diagnostic.Message)
+}
+
+// LintOptions describe the printer the ZPL is headed for
+type LintOptions struct {
+	// Media size in dots, for labels that don't set ^PW/^LL; 0 if unknown
+	Width  int
+	Length int
+}
+
+// HasErrors is true if any of the diagnostics is an error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Format commands (^ by default) a printer knows
+var formatCommands = map[string]bool{
+	"A": true, "A@": true,
+	"B0": true, "B1": true, "B2": true, "B3": true, "B4": true, "B5": true, "B7": true, "B8": true,
+	"B9": true, "BA": true, "BB": true, "BC": true, "BD": true, "BE": true, "BF": true, "BI": true,
+	"BJ": true, "BK": true, "BL": true, "BM": true, "BO": true, "BP": true, "BQ": true, "BR": true,
+	"BS": true, "BT": true, "BU": true, "BX": true, "BY": true, "BZ": true,
+	"CC": true, "CD": true, "CF": true, "CI": true, "CM": true, "CN": true, "CO": true, "CP": true,
+	"CT": true, "CV": true, "CW": true,
+	"DF": true,
+	"FA": true, "FB": true, "FC": true, "FD": true, "FE": true, "FH": true, "FL": true, "FM": true,
+	"FN": true, "FO": true, "FP": true, "FR": true, "FS": true, "FT": true, "FV": true, "FW": true,
+	"FX": true,
+	"GB": true, "GC": true, "GD": true, "GE": true, "GF": true, "GS": true,
+	"HF": true, "HG": true, "HH": true, "HT": true, "HV": true, "HW": true, "HY": true, "HZ": true,
+	"ID": true, "IL": true, "IM": true, "IS": true,
+	"JB": true, "JF": true, "JH": true, "JI": true, "JJ": true, "JM": true, "JS": true, "JT": true,
+	"JU": true, "JW": true, "JZ": true,
+	"KD": true, "KL": true, "KN": true, "KP": true, "KV": true,
+	"LF": true, "LH": true, "LL": true, "LR": true, "LS": true, "LT": true,
+	"MA": true, "MC": true, "MD": true, "MF": true, "MI": true, "MK": true, "ML": true, "MM": true,
+	"MN": true, "MP": true, "MT": true, "MU": true, "MW": true,
+	"NB": true, "NC": true, "NI": true, "NN": true, "NP": true, "NS": true, "NT": true, "NW": true,
+	"PA": true, "PF": true, "PH": true, "PM": true, "PN": true, "PO": true, "PP": true, "PQ": true,
+	"PR": true, "PS": true, "PW": true,
+	"SC": true, "SE": true, "SF": true, "SI": true, "SL": true, "SN": true, "SO": true, "SP": true,
+	"SQ": true, "SR": true, "SS": true, "ST": true, "SX": true, "SZ": true,
+	"TB": true, "TO": true,
+	"WA": true, "WD": true, "WE": true, "WF": true, "WL": true, "WP": true, "WR": true, "WS": true,
+	"WT": true, "WV": true,
+	"XA": true, "XB": true, "XF": true, "XG": true, "XS": true, "XZ": true,
+	"ZZ": true,
+}
+
+// Control commands (~ by default) a printer knows
+var controlCommands = map[string]bool{
+	"CC": true, "CD": true, "CT": true,
+	"DB": true, "DE": true, "DG": true, "DN": true, "DS": true, "DT": true, "DU": true, "DY": true,
+	"EG": true,
+	"HB": true, "HD": true, "HI": true, "HM": true, "HQ": true, "HS": true, "HU": true,
+	"JA": true, "JB": true, "JC": true, "JD": true, "JE": true, "JF": true, "JG": true, "JI": true,
+	"JL": true, "JN": true, "JO": true, "JP": true, "JQ": true, "JR": true, "JS": true, "JX": true,
+	"KB": true,
+	"NC": true, "NR": true, "NT": true,
+	"PH": true, "PL": true, "PM": true, "PP": true, "PR": true, "PS": true,
+	"RO": true,
+	"SD": true,
+	"TA": true,
+	"WC": true, "WQ": true, "WR": true,
+}
+
+// Format commands that mean something outside ^XA/^XZ
+var outsideCommands = map[string]bool{
+	"CC": true, "CD": true, "CT": true, "XZ": true,
+}
+
+// Commands that take no parameters, so anything after them is ignored
+var bareCommands = map[string]bool{
+	"FR": true, "FS": true, "XA": true, "XZ": true,
+}
+
+// What one of a command's parameters may be
+type paramSpec struct {
+	name string
+	// Single-character choices, or "" for a number
+	choices string
+	min     float64
+	max     float64
+	// Numbers with a fractional part are allowed
+	fractional bool
+}
+
+func number(name string, min, max int) paramSpec {
+	return paramSpec{name: name, min: float64(min), max: float64(max)}
+}
+
+func fraction(name string, min, max float64) paramSpec {
+	return paramSpec{name: name, min: min, max: max, fractional: true}
+}
+
+func choice(name string, choices string) paramSpec {
+	return paramSpec{name: name, choices: choices}
+}
+
+var (
+	orientationParam = choice("orientation", "NRIB")
+	heightParam      = number("height", 1, 32000)
+	lineParam        = choice("interpretation line", "YN")
+	aboveParam       = choice("interpretation line above", "YN")
+	checkParam       = choice("check digit", "YN")
+	colorParam       = choice("color", "BW")
+	dotsX            = number("x", 0, 32000)
+	dotsY            = number("y", 0, 32000)
+)
+
+// Parameters, in order, of the commands that get checked; parameters
+// beyond the ones listed aren't
+var commandParams = map[string][]paramSpec{
+	"A@": {orientationParam, number("height", 1, 32000), number("width", 1, 32000)},
+	"B2": {orientationParam, heightParam, lineParam, aboveParam, checkParam},
+	"B3": {orientationParam, checkParam, heightParam, lineParam, aboveParam},
+	"B8": {orientationParam, heightParam, lineParam, aboveParam},
+	"B9": {orientationParam, heightParam, lineParam, aboveParam, checkParam},
+	"BA": {orientationParam, heightParam, lineParam, aboveParam, checkParam},
+	"BC": {orientationParam, heightParam, lineParam, aboveParam, checkParam, choice("mode", "NUAD")},
+	"BE": {orientationParam, heightParam, lineParam, aboveParam},
+	"BK": {orientationParam, checkParam, heightParam, lineParam, aboveParam, choice("start character", "ABCD"), choice("stop character", "ABCD")},
+	"BQ": {choice("orientation", "N"), number("model", 1, 2), number("magnification", 1, 100), choice("error correction", "HQML"), number("mask", 0, 7)},
+	"BU": {orientationParam, heightParam, lineParam, aboveParam, checkParam},
+	"BX": {orientationParam, heightParam, number("quality", 0, 200), number("columns", 9, 144), number("rows", 9, 144), number("format", 1, 6)},
+	"BY": {number("module width", 1, 10), fraction("ratio", 2, 3), heightParam},
+	"CI": {number("character set", 0, 36)},
+	"FB": {number("width", 0, 32000), number("lines", 1, 9999), number("line spacing", -9999, 9999), choice("justification", "LCRJ"), number("hanging indent", 0, 9999)},
+	"FO": {dotsX, dotsY, number("justification", 0, 2)},
+	"FT": {dotsX, dotsY, number("justification", 0, 2)},
+	"FW": {orientationParam, number("justification", 0, 2)},
+	"GB": {number("width", 1, 32000), number("height", 1, 32000), number("thickness", 1, 32000), colorParam, number("rounding", 0, 8)},
+	"GC": {number("diameter", 3, 4095), number("thickness", 1, 4095), colorParam},
+	"GE": {number("width", 3, 4095), number("height", 3, 4095), number("thickness", 2, 4095), colorParam},
+	"GF": {choice("format", "ABC"), number("data bytes", 1, 99999), number("total bytes", 1, 99999), number("bytes per row", 1, 99999)},
+	"LH": {dotsX, dotsY},
+	"LL": {number("length", 1, 32000)},
+	"LS": {number("shift", -9999, 9999)},
+	"LT": {number("top", -120, 120)},
+	"MD": {fraction("darkness", -30, 30)},
+	"MM": {choice("mode", "TPRACDFLUK"), choice("prepeel", "YN")},
+	"MN": {choice("media tracking", "NYWMAV")},
+	"MT": {choice("media type", "TD")},
+	"PM": {choice("mirror", "YN")},
+	"PO": {choice("orientation", "NI")},
+	"PQ": {number("quantity", 1, 99999999), number("pause every", 0, 99999999), number("replicates", 0, 99999999), choice("override pause", "YN"), choice("cut on error", "YN")},
+	"PW": {number("width", 2, 32000)},
+}
+
+// Characters each linear symbology can encode
+var symbologyCharacters = map[string]string{
+	"B2": "0123456789",
+	"B3": "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-. $/+%",
+	"B8": "0123456789",
+	"B9": "0123456789",
+	"BE": "0123456789",
+	"BK": "0123456789-$:/.+ABCD",
+	"BU": "0123456789",
+}
+
+var symbologyNames = map[string]string{
+	"B0": "Aztec",
+	"B1": "Code 11",
+	"B2": "Interleaved 2 of 5",
+	"B3": "Code 39",
+	"B4": "Code 49",
+	"B5": "Planet Code",
+	"B7": "PDF417",
+	"B8": "EAN-8",
+	"B9": "UPC-E",
+	"BA": "Code 93",
+	"BB": "CODABLOCK",
+	"BC": "Code 128",
+	"BD": "MaxiCode",
+	"BE": "EAN-13",
+	"BF": "MicroPDF417",
+	"BI": "Industrial 2 of 5",
+	"BJ": "Standard 2 of 5",
+	"BK": "Codabar",
+	"BL": "LOGMARS",
+	"BM": "MSI",
+	"BO": "Aztec",
+	"BP": "Plessey",
+	"BQ": "QR",
+	"BR": "GS1 DataBar",
+	"BS": "UPC/EAN extension",
+	"BT": "TLC39",
+	"BU": "UPC-A",
+	"BX": "Data Matrix",
+	"BZ": "POSTNET",
+}
+
+// What to call a barcode command's symbology in messages, the command
+// itself for ones the table doesn't know
+func symbologyName(barcode *Command) string {
+	if name, ok := symbologyNames[barcode.Name]; ok {
+		return name
+	}
+
+	return "^" + barcode.Name
+}
+
+// How many digits a symbology takes before the printer's check digit;
+// longer data gets cut short and shorter data padded with zeros
+var symbologyDigits = map[string]int{
+	"B8": 7,
+	"BE": 12,
+	"BU": 11,
+}
+
+type linter struct {
+	options     LintOptions
+	diagnostics []Diagnostic
+}
+
+func (linter *linter) report(severity Severity, position Position, command *Command, format string, args ...interface{}) {
+	diagnostic := Diagnostic{Position: position, Severity: severity, Message: fmt.Sprintf(format, args...)}
+
+	if command != nil {
+		diagnostic.Command = string(command.Prefix) + command.Name
+	}
+
+	linter.diagnostics = append(linter.diagnostics, diagnostic)
+}
+
+// Lint looks for mistakes in ZPL source that would waste a label
+func Lint(source string, options LintOptions) []Diagnostic {
+	return Parse(source).Lint(options)
+}
+
+// Lint looks for mistakes in a parsed document, in source order
+func (document *Document) Lint(options LintOptions) []Diagnostic {
+	linter := &linter{options: options}
+
+	for _, command := range document.Commands {
+		linter.checkCommand(command)
+	}
+
+	for _, command := range document.Outside {
+		if !command.Control && !outsideCommands[command.Name] && knownCommand(command) {
+			linter.report(Warning, command.Pos, command, "%v%v is outside ^XA/^XZ and will be ignored", string(command.Prefix), command.Name)
+		} else if command.Name == "XZ" && !command.Control {
+			linter.report(Warning, command.Pos, command, "^XZ without a ^XA to end")
+		}
+	}
+
+	for _, text := range document.Stray {
+		linter.report(Warning, text.Pos, nil, "Text %q isn't part of any command and will be ignored", strings.TrimSpace(text.Params))
+	}
+
+	width, length := options.Width, options.Length
+
+	for _, label := range document.Labels {
+		width, length = linter.checkLabel(label, width, length)
+	}
+
+	// Put them in source order, keeping the order they were found in for
+	// the same place
+	sort.SliceStable(linter.diagnostics, func(i, j int) bool {
+		return linter.diagnostics[i].Offset < linter.diagnostics[j].Offset
+	})
+
+	return linter.diagnostics
+}
+
+func knownCommand(command *Command) bool {
+	if command.Control {
+		return controlCommands[command.Name]
+	}
+
+	return formatCommands[command.Name]
+}
+
+func (linter *linter) checkCommand(command *Command) {
+	if !knownCommand(command) {
+		linter.report(Warning, command.Pos, command, "Unknown command %v%v", string(command.Prefix), command.Name)
+		return
+	}
+
+	if command.Control {
+		return
+	}
+
+	if bareCommands[command.Name] {
+		if text := strings.TrimSpace(command.Params); text != "" {
+			linter.report(Warning, command.ParamsPos, command, "Text %q after ^%v will be ignored", text, command.Name)
+		}
+
+		return
+	}
+
+	if command.Name == "A" {
+		linter.checkFont(command)
+		return
+	}
+
+	for index, spec := range commandParams[command.Name] {
+		arg := command.Arg(index)
+
+		if arg == "" {
+			continue
+		}
+
+		if message := spec.check(arg); message != "" {
+			linter.report(Error, command.ParamsPos, command, "^%v %v %v", command.Name, spec.name, message)
+		}
+	}
+}
+
+// ^A takes its font from the character after it: ^A0N,30,20
+func (linter *linter) checkFont(command *Command) {
+	font := command.Arg(0)
+
+	if font == "" {
+		linter.report(Error, command.Pos, command, "^A needs a font")
+		return
+	}
+
+	if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", rune(strings.ToUpper(font)[0])) {
+		linter.report(Error, command.ParamsPos, command, "^A font %q should be A-Z or 0-9", font[:1])
+	}
+
+	if len(font) > 1 {
+		if message := orientationParam.check(font[1:]); message != "" {
+			linter.report(Error, command.ParamsPos, command, "^A orientation %v", message)
+		}
+	}
+
+	for index, name := range []string{"height", "width"} {
+		if arg := command.Arg(index + 1); arg != "" {
+			if message := number(name, 10, 32000).check(arg); message != "" {
+				linter.report(Error, command.ParamsPos, command, "^A %v %v", name, message)
+			}
+		}
+	}
+}
+
+// What's wrong with a parameter, or "" if nothing is
+func (spec paramSpec) check(arg string) string {
+	if spec.choices != "" {
+		if len(arg) != 1 || !strings.Contains(spec.choices, strings.ToUpper(arg)) {
+			return fmt.Sprintf("%q should be one of %v", arg, strings.Join(strings.Split(spec.choices, ""), ", "))
+		}
+
+		return ""
+	}
+
+	var value float64
+	var err error
+
+	if spec.fractional {
+		value, err = strconv.ParseFloat(arg, 64)
+	} else {
+		var whole int
+		whole, err = strconv.Atoi(arg)
+		value = float64(whole)
+	}
+
+	if err != nil {
+		return fmt.Sprintf("%q isn't a number", arg)
+	}
+
+	if value < spec.min || value > spec.max {
+		return fmt.Sprintf("%v is outside %v to %v", arg, spec.min, spec.max)
+	}
+
+	return ""
+}
+
+// Check a label's structure, fields and barcodes, and return the print
+// width and length it leaves the printer with
+func (linter *linter) checkLabel(label *Label, width, length int) (int, int) {
+	if label.End == nil {
+		linter.report(Error, label.Start.Pos, label.Start, "^XA has no ^XZ to end the label")
+	}
+
+	if command := label.Find("PW"); command != nil {
+		if value, ok := command.Int(0, 0); ok && value > 0 {
+			width = value
+		}
+	}
+
+	if command := label.Find("LL"); command != nil {
+		if value, ok := command.Int(0, 0); ok && value > 0 {
+			length = value
+		}
+	}
+
+	homeX, homeY := 0, 0
+
+	for _, command := range label.Commands {
+		switch command.Name {
+		case "LH":
+			homeX, _ = command.Int(0, 0)
+			homeY, _ = command.Int(1, 0)
+		case "FO", "FT":
+			if command.Arg(0) == "" && command.Arg(1) == "" {
+				// ^FT with no position carries on from the last field
+				continue
+			}
+
+			x, okX := command.Int(0, 0)
+			y, okY := command.Int(1, 0)
+
+			if !okX || !okY {
+				continue
+			}
+
+			x, y = x+homeX, y+homeY
+
+			if (width > 0 && x >= width) || (length > 0 && y >= length) {
+				linter.report(Warning, command.Pos, command, "^%v%v,%v is outside the %vx%v dot label", command.Name, command.Arg(0), command.Arg(1), sizeOrUnknown(width), sizeOrUnknown(length))
+			}
+		}
+	}
+
+	for _, field := range label.Fields {
+		linter.checkField(field)
+	}
+
+	return width, length
+}
+
+func sizeOrUnknown(dots int) string {
+	if dots <= 0 {
+		return "?"
+	}
+
+	return strconv.Itoa(dots)
+}
+
+func (linter *linter) checkField(field *Field) {
+	if field.End == nil {
+		if field.Data != nil {
+			linter.report(Error, field.Data.Pos, field.Data, "^%v has no ^FS to end the field", field.Data.Name)
+		} else {
+			linter.report(Warning, field.Pos(), field.Commands[0], "Field has no ^FS to end it")
+		}
+	}
+
+	barcode := field.Barcode()
+
+	if barcode == nil {
+		return
+	}
+
+	if field.Data == nil {
+		if field.Find("FN") == nil {
+			linter.report(Warning, barcode.Pos, barcode, "^%v barcode has no ^FD data", barcode.Name)
+		}
+
+		return
+	}
+
+	for _, message := range barcodeProblems(barcode, field.Text) {
+		linter.report(message.severity, field.Data.ParamsPos, barcode, "%v", message.text)
+	}
+}
+
+type barcodeProblem struct {
+	severity Severity
+	text     string
+}
+
+// Whether data can be encoded with the barcode command's symbology
+func barcodeProblems(barcode *Command, data string) []barcodeProblem {
+	name := symbologyName(barcode)
+	var problems []barcodeProblem
+
+	if data == "" {
+		return []barcodeProblem{{Error, fmt.Sprintf("%v barcode has no data", name)}}
+	}
+
+	if characters, ok := symbologyCharacters[barcode.Name]; ok {
+		for _, char := range data {
+			if !strings.ContainsRune(characters, char) {
+				return []barcodeProblem{{Error, fmt.Sprintf("%v can't encode %q", name, char)}}
+			}
+		}
+	}
+
+	switch barcode.Name {
+	case "BA", "BC":
+		for _, char := range data {
+			if char > 127 {
+				return []barcodeProblem{{Error, fmt.Sprintf("%v can't encode %q", name, char)}}
+			}
+		}
+
+		if barcode.Name == "BC" && strings.ToUpper(barcode.Arg(5)) == "U" && strings.Trim(data, "0123456789") != "" {
+			problems = append(problems, barcodeProblem{Error, "Code 128 UCC case mode takes only digits"})
+		}
+	case "BK":
+		inner := strings.Trim(data, "ABCD")
+		if strings.ContainsAny(inner, "ABCD") {
+			problems = append(problems, barcodeProblem{Error, "Codabar start and stop characters A-D can only go at the ends"})
+		}
+	case "BQ":
+		if len(data) < 3 || !strings.Contains("HQML", strings.ToUpper(data[:1])) || !strings.Contains("AM", strings.ToUpper(data[1:2])) || data[2] != ',' {
+			problems = append(problems, barcodeProblem{Error, "QR data should start with an error correction level and input mode, like QA,"})
+		}
+	}
+
+	if digits, ok := symbologyDigits[barcode.Name]; ok && len(data) != digits {
+		problems = append(problems, barcodeProblem{Warning, fmt.Sprintf("%v takes %v digits (the printer adds the check digit), not %v", name, digits, len(data))})
+	}
+
+	if barcode.Name == "B2" {
+		length := len(data)
+		if strings.ToUpper(barcode.Arg(4)) == "Y" {
+			length++
+		}
+
+		if length%2 != 0 {
+			problems = append(problems, barcodeProblem{Warning, "Interleaved 2 of 5 needs an even number of digits, so the printer adds a leading zero"})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,65 @@
+package zpl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	options := LintOptions{Width: 812, Length: 1218}
+
+	cases := []struct {
+		source string
+		// The diagnostics expected, each as "severity command: start of message"
+		want []string
+	}{
+		{"^XA^FO0,0^A0N,30,30^FDok^FS^XZ", nil},
+		{"^XA^CC!!FO0,0!FDok!FS!XZ", nil},
+		{"^XA^CD;^FO0;0^FDa,b^FS^XZ", nil},
+		{"~CT+^XA+JA^XZ", nil},
+		{"^XA^FO0,0^FDx^XZ", []string{"error ^FD: ^FD has no ^FS"}},
+		{"^XA^FO0,0^FDx^FS", []string{"error ^XA: ^XA has no ^XZ"}},
+		{"^XA^QQ^XZ", []string{"warning ^QQ: Unknown command ^QQ"}},
+		{"^XA^FOa,0^FDx^FS^XZ", []string{`error ^FO: ^FO x "a" isn't a number`}},
+		{"hello^XA^XZ", []string{`warning : Text "hello"`}},
+		{"^XA^PW100^FO500,0^FDx^FS^XZ", []string{"warning ^FO: ^FO500,0 is outside the 100x1218 dot label"}},
+		{"^XA^BY0^FO0,0^BCN,50^FD123^FS^XZ", []string{"error ^BY: ^BY module width 0 is outside 1 to 10"}},
+		{"^XA^FO0,0^BCN,50^FD^FS^XZ", []string{"error ^BC: Code 128 barcode has no data"}},
+		{"^XA^FO0,0^BXN,5,200^FD^FS^XZ", []string{"error ^BX: Data Matrix barcode has no data"}},
+		{"^XA^FO0,0^B7N,5^FD^FS^XZ", []string{"error ^B7: PDF417 barcode has no data"}},
+		{"^XA^FO0,0^BWN^FD^FS^XZ", []string{"warning ^BW: Unknown command ^BW", "error ^BW: ^BW barcode has no data"}},
+		{"^XA^FO0,0^BCN,50^FDé^FS^XZ", []string{"error ^BC: Code 128 can't encode 'é'"}},
+	}
+
+	for _, test := range cases {
+		diagnostics := Lint(test.source, options)
+		var got []string
+
+		for _, diagnostic := range diagnostics {
+			got = append(got, string(diagnostic.Severity)+" "+diagnostic.Command+": "+diagnostic.Message)
+		}
+
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %q, want %q", test.source, got, test.want)
+			continue
+		}
+
+		for index := range got {
+			if !strings.HasPrefix(got[index], test.want[index]) {
+				t.Errorf("%q: got %q, want %q", test.source, got[index], test.want[index])
+			}
+		}
+	}
+}
+
+func TestLintPositions(t *testing.T) {
+	diagnostics := Lint("^XA\n^FO0,0\n^BXN,5,200^FD^FS\n^XZ", LintOptions{})
+
+	if len(diagnostics) != 1 {
+		t.Fatalf("got %v, want one diagnostic", diagnostics)
+	}
+
+	if want := (Position{Offset: 24, Line: 3, Column: 14}); diagnostics[0].Position != want {
+		t.Errorf("diagnostic is at %#v, want %#v", diagnostics[0].Position, want)
+	}
+}
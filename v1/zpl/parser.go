@@ -0,0 +1,326 @@
+package zpl
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Command is one ZPL command with its parameters split up
+type Command struct {
+	Token
+	// Parameters split on the delimiter in effect (, unless ^CD changed
+	// it) with surrounding whitespace trimmed; field data isn't split
+	Args []string
+}
+
+// Arg is a parameter, or "" if it wasn't given
+func (command *Command) Arg(index int) string {
+	if index < len(command.Args) {
+		return command.Args[index]
+	}
+
+	return ""
+}
+
+// Int is a numeric parameter, or fallback if it wasn't given. ok is false if
+// it was given but isn't a number.
+func (command *Command) Int(index int, fallback int) (value int, ok bool) {
+	arg := command.Arg(index)
+
+	if arg == "" {
+		return fallback, true
+	}
+
+	value, err := strconv.Atoi(arg)
+
+	if err != nil {
+		return fallback, false
+	}
+
+	return value, true
+}
+
+// IsBarcode is true for the ^B commands that draw a barcode (not ^BY, which sets defaults)
+func (command *Command) IsBarcode() bool {
+	return !command.Control && len(command.Name) == 2 && command.Name[0] == 'B' && command.Name != "BY"
+}
+
+// Field is the commands that build up one thing on a label, up to its ^FS
+type Field struct {
+	Commands []*Command
+	// ^FO or ^FT, if the field has one
+	Origin *Command
+	// ^FD or ^FV, if the field has one
+	Data *Command
+	// The field's data with any ^FH escapes decoded and line breaks (which
+	// printers ignore) taken out
+	Text string
+	// ^FS, or nil if the label ended first
+	End *Command
+}
+
+// Pos is where the field starts
+func (field *Field) Pos() Position {
+	return field.Commands[0].Pos
+}
+
+// Find the field's first command with one of the names
+func (field *Field) Find(names ...string) *Command {
+	for _, command := range field.Commands {
+		for _, name := range names {
+			if command.Name == name {
+				return command
+			}
+		}
+	}
+
+	return nil
+}
+
+// Barcode is the field's ^B command, or nil if it isn't a barcode
+func (field *Field) Barcode() *Command {
+	for _, command := range field.Commands {
+		if command.IsBarcode() {
+			return command
+		}
+	}
+
+	return nil
+}
+
+// Label is a format between ^XA and ^XZ
+type Label struct {
+	// ^XA, and ^XZ or nil if the source ended first
+	Start *Command
+	End   *Command
+	// Everything in between, in order
+	Commands []*Command
+	Fields   []*Field
+}
+
+// Find the label's last command with the name, which is the one that wins
+func (label *Label) Find(name string) *Command {
+	for index := len(label.Commands) - 1; index >= 0; index-- {
+		if label.Commands[index].Name == name {
+			return label.Commands[index]
+		}
+	}
+
+	return nil
+}
+
+// Document is a whole stream of ZPL
+type Document struct {
+	Source string
+	// Every command in order, in labels or not
+	Commands []*Command
+	Labels   []*Label
+	// Commands outside any label, mostly ~ control commands
+	Outside []*Command
+	// Text between commands that isn't whitespace
+	Stray []Token
+}
+
+// Commands that make up a field rather than setting something for the whole label
+var fieldCommands = map[string]bool{
+	"A": true, "A@": true,
+	"FO": true, "FT": true, "FD": true, "FV": true, "FH": true, "FB": true,
+	"FR": true, "FN": true, "FP": true, "FC": true, "FA": true, "TB": true,
+	"GB": true, "GC": true, "GD": true, "GE": true, "GF": true, "GS": true,
+	"XG": true, "IM": true,
+}
+
+func isFieldCommand(command *Command) bool {
+	return !command.Control && (fieldCommands[command.Name] || command.IsBarcode())
+}
+
+// DecodeHex turns ^FH escapes (_1F and the like, for an indicator of _)
+// back into the bytes they stand for
+func DecodeHex(data string, indicator byte) string {
+	var decoded strings.Builder
+
+	for index := 0; index < len(data); index++ {
+		if data[index] == indicator && index+2 < len(data) {
+			if value, err := hex.DecodeString(data[index+1 : index+3]); err == nil {
+				decoded.Write(value)
+				index += 2
+				continue
+			}
+		}
+
+		decoded.WriteByte(data[index])
+	}
+
+	return decoded.String()
+}
+
+// Parse reads a stream of ZPL into labels, fields and commands
+func Parse(source string) *Document {
+	document := &Document{Source: source}
+	lexer := NewLexer(source)
+	delimiter := byte(DefaultDelimiter)
+
+	var label *Label
+	var field *Field
+	var hexIndicator byte
+
+	closeField := func(end *Command) {
+		if field != nil {
+			field.End = end
+			label.Fields = append(label.Fields, field)
+		}
+
+		field = nil
+		hexIndicator = 0
+	}
+
+	for {
+		token, ok := lexer.Next()
+
+		if !ok {
+			break
+		}
+
+		if token.Kind == TextToken {
+			document.Stray = append(document.Stray, token)
+			continue
+		}
+
+		command := &Command{Token: token}
+
+		if dataCommands[token.Name] {
+			command.Args = []string{token.Params}
+		} else {
+			command.Args = strings.Split(token.Params, string(delimiter))
+			for index := range command.Args {
+				command.Args[index] = strings.TrimSpace(command.Args[index])
+			}
+		}
+
+		document.Commands = append(document.Commands, command)
+
+		if token.Name == "CD" && len(token.Params) > 0 {
+			delimiter = token.Params[0]
+		}
+
+		switch {
+		case token.Name == "XA" && !token.Control:
+			if label != nil {
+				// A new label before the last one ended
+				closeField(nil)
+				document.Labels = append(document.Labels, label)
+			}
+
+			label = &Label{Start: command}
+			continue
+		case label == nil:
+			document.Outside = append(document.Outside, command)
+			continue
+		case token.Name == "XZ" && !token.Control:
+			closeField(nil)
+			label.End = command
+			document.Labels = append(document.Labels, label)
+			label = nil
+			continue
+		}
+
+		label.Commands = append(label.Commands, command)
+
+		if token.Name == "FS" && !token.Control {
+			if field == nil {
+				field = &Field{}
+			}
+
+			field.Commands = append(field.Commands, command)
+			closeField(command)
+			continue
+		}
+
+		if field == nil && !isFieldCommand(command) {
+			continue
+		} else if field == nil {
+			field = &Field{}
+		}
+
+		field.Commands = append(field.Commands, command)
+
+		switch token.Name {
+		case "FO", "FT":
+			field.Origin = command
+		case "FH":
+			hexIndicator = '_'
+			if params := strings.TrimSpace(token.Params); len(params) > 0 {
+				hexIndicator = params[0]
+			}
+		case "FD", "FV":
+			field.Data = command
+			field.Text = strings.NewReplacer("\r", "", "\n", "").Replace(token.Params)
+
+			if hexIndicator != 0 {
+				field.Text = DecodeHex(field.Text, hexIndicator)
+			}
+		}
+	}
+
+	if label != nil {
+		closeField(nil)
+		document.Labels = append(document.Labels, label)
+	}
+
+	return document
+}
+
+// Control commands that download something into printer memory for labels
+// to use later
+var downloadCommands = map[string]bool{
+	"DB": true, "DE": true, "DG": true, "DS": true, "DT": true, "DU": true, "DY": true,
+}
+
+// SplitLabels cuts the source up into one piece per label, so each can be
+// sent on its own. Anything between labels goes with the label after it,
+// and anything after the last label goes with that one. Prefix and
+// delimiter changes and downloads from earlier pieces are copied to the
+// front of each piece, so it reads and prints the same as it would have
+// in the whole stream.
+func (document *Document) SplitLabels() []string {
+	if len(document.Labels) == 0 {
+		return []string{document.Source}
+	}
+
+	outside := make(map[*Command]bool, len(document.Outside))
+	for _, command := range document.Outside {
+		outside[command] = true
+	}
+
+	pieces := make([]string, 0, len(document.Labels))
+	var setup strings.Builder
+	start := 0
+	next := 0
+
+	for index, label := range document.Labels {
+		end := len(document.Source)
+
+		if index+1 < len(document.Labels) {
+			end = document.Labels[index+1].Start.Pos.Offset
+
+			if label.End != nil {
+				end = label.End.End.Offset
+			}
+		}
+
+		pieces = append(pieces, setup.String()+document.Source[start:end])
+
+		for ; next < len(document.Commands) && document.Commands[next].Pos.Offset < end; next++ {
+			command := document.Commands[next]
+
+			if prefixCommands[command.Name] || (outside[command] && command.Control && downloadCommands[command.Name]) {
+				setup.WriteString(document.Source[command.Pos.Offset:command.End.Offset])
+			}
+		}
+
+		start = end
+	}
+
+	return pieces
+}
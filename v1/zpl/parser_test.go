@@ -0,0 +1,166 @@
+package zpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDelimiter(t *testing.T) {
+	cases := []struct {
+		source string
+		want   []string
+	}{
+		{"^XA^FO10,20^FS^XZ", []string{"10", "20"}},
+		{"^XA^FO 10 , 20 ^FS^XZ", []string{"10", "20"}},
+		{"^XA^CD;^FO10;20^FS^XZ", []string{"10", "20"}},
+		{"^XA^CD;^FO10,20^FS^XZ", []string{"10,20"}},
+		{"^CC!!XA!CD|!FO10|20!FS!XZ", []string{"10", "20"}},
+		{"^XA^FO^FS^XZ", []string{""}},
+	}
+
+	for _, test := range cases {
+		document := Parse(test.source)
+
+		if len(document.Labels) != 1 || len(document.Labels[0].Fields) != 1 {
+			t.Errorf("%q: want one label with one field, got %+v", test.source, document.Labels)
+			continue
+		}
+
+		origin := document.Labels[0].Fields[0].Origin
+
+		if origin == nil {
+			t.Errorf("%q: field has no origin", test.source)
+		} else if !reflect.DeepEqual(origin.Args, test.want) {
+			t.Errorf("%q: ^FO args are %q, want %q", test.source, origin.Args, test.want)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	cases := []struct {
+		name    string
+		source  string
+		labels  int
+		fields  int
+		ended   bool
+		stray   int
+		outside int
+	}{
+		{"empty", "", 0, 0, false, 0, 0},
+		{"just text", "hello", 0, 0, false, 1, 0},
+		{"no ^XZ", "^XA^FO0,0^FDx^FS", 1, 1, false, 0, 0},
+		{"no ^FS", "^XA^FO0,0^FDx^XZ", 1, 1, true, 0, 0},
+		{"^XA inside a label", "^XA^FDx^FS^XA^FDy^FS^XZ", 2, 1, true, 0, 0},
+		{"^XZ with no label", "^XZ^FDx^FS", 0, 0, false, 0, 3},
+		{"control commands between labels", "~JA^XA^XZ~HS", 1, 0, true, 0, 2},
+		{"text around a label", "a^XA^XZb", 1, 0, true, 1, 0},
+		{"unterminated hex escape", "^XA^FH^FDab_4^FS^XZ", 1, 1, true, 0, 0},
+	}
+
+	for _, test := range cases {
+		document := Parse(test.source)
+
+		if len(document.Labels) != test.labels {
+			t.Errorf("%v: got %v labels, want %v", test.name, len(document.Labels), test.labels)
+			continue
+		}
+
+		if len(document.Stray) != test.stray || len(document.Outside) != test.outside {
+			t.Errorf("%v: got %v stray and %v outside, want %v and %v", test.name, len(document.Stray), len(document.Outside), test.stray, test.outside)
+		}
+
+		if test.labels == 0 {
+			continue
+		}
+
+		last := document.Labels[len(document.Labels)-1]
+
+		if len(last.Fields) != test.fields {
+			t.Errorf("%v: last label has %v fields, want %v", test.name, len(last.Fields), test.fields)
+		}
+
+		if (last.End != nil) != test.ended {
+			t.Errorf("%v: last label ended is %v, want %v", test.name, last.End != nil, test.ended)
+		}
+	}
+}
+
+func TestParseFieldText(t *testing.T) {
+	cases := []struct {
+		source string
+		want   string
+	}{
+		{"^XA^FDplain^FS^XZ", "plain"},
+		{"^XA^FDline\r\nbreak^FS^XZ", "linebreak"},
+		{"^XA^FH^FDa_41_42c^FS^XZ", "aABc"},
+		{"^XA^FH#^FDa#41_42^FS^XZ", "aA_42"},
+		{"^XA^FH^FDa_4^FS^XZ", "a_4"},
+		{"^XA^FH^FDa_zz^FS^XZ", "a_zz"},
+		{"^XA^FDa,b~c^FS^XZ", "a,b~c"},
+	}
+
+	for _, test := range cases {
+		document := Parse(test.source)
+
+		if len(document.Labels) != 1 || len(document.Labels[0].Fields) != 1 {
+			t.Errorf("%q: want one label with one field", test.source)
+		} else if got := document.Labels[0].Fields[0].Text; got != test.want {
+			t.Errorf("%q: field text is %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestSplitLabels(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"no labels", "~JA", []string{"~JA"}},
+		{"one label", "^XA^FDa^FS^XZ", []string{"^XA^FDa^FS^XZ"}},
+		{
+			"between and after labels",
+			"~SD20^XA^XZ\n~JA^XA^XZ\n",
+			[]string{"~SD20^XA^XZ\n", "~JA^XA^XZ\n"},
+		},
+		{
+			"unended label",
+			"^XA^FDa^FS^XA^FDb^FS^XZ",
+			[]string{"^XA^FDa^FS", "^XA^FDb^FS^XZ"},
+		},
+		{
+			"format prefix change",
+			"^CC!!XA!XZ!XA!XZ",
+			[]string{"^CC!!XA!XZ", "^CC!!XA!XZ"},
+		},
+		{
+			"prefix and delimiter changes inside labels",
+			"^XA^CC!!XZ!XA!CD;!XZ!XA!XZ",
+			[]string{"^XA^CC!!XZ", "^CC!!XA!CD;!XZ", "^CC!!CD;!XA!XZ"},
+		},
+		{
+			"control prefix change and download",
+			"~CT+\n+DGR:A.GRF,2,1,FF00\n^XA^XZ^XA+JA^XZ",
+			[]string{"~CT+\n+DGR:A.GRF,2,1,FF00\n^XA^XZ", "~CT+\n+DGR:A.GRF,2,1,FF00\n^XA+JA^XZ"},
+		},
+		{
+			"downloads before the first label",
+			"~DGR:A.GRF,2,1,FF00\n~DYR:B,A,G,2,,FF\n^XA^XGR:A.GRF^XZ^XA^XGR:A.GRF^XZ",
+			[]string{
+				"~DGR:A.GRF,2,1,FF00\n~DYR:B,A,G,2,,FF\n^XA^XGR:A.GRF^XZ",
+				"~DGR:A.GRF,2,1,FF00\n~DYR:B,A,G,2,,FF\n^XA^XGR:A.GRF^XZ",
+			},
+		},
+		{
+			"other control commands don't get copied",
+			"~SD20~JA^XA^XZ^XA^XZ",
+			[]string{"~SD20~JA^XA^XZ", "^XA^XZ"},
+		},
+	}
+
+	for _, test := range cases {
+		if got := Parse(test.source).SplitLabels(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: split %q into %q, want %q", test.name, test.source, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,714 @@
+package zpl
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// Resolution and label size assumed when nothing says otherwise: 4x6" at 203 dpi
+const (
+	defaultDPI    = 203
+	defaultWidth  = 812
+	defaultLength = 1218
+)
+
+// Nothing is drawn bigger than the biggest label a printer takes: 8.5" wide
+// print heads, 39" of label, 600 dpi
+const (
+	maxWidthInches  = 8.5
+	maxLengthInches = 39
+	maxDPI          = 600
+)
+
+// RenderOptions describe the printer a preview is for
+type RenderOptions struct {
+	DPI int
+	// Media size in dots, for labels that don't set ^PW/^LL
+	Width  int
+	Length int
+}
+
+// How a mask's ink goes onto the label
+type ink int
+
+const (
+	inkBlack ink = iota
+	inkWhite
+	// ^FR: black where the label is white and white where it's black
+	inkReverse
+)
+
+// Settings that carry over from field to field and label to label, like
+// they do on a printer
+type renderState struct {
+	width, length int
+	homeX, homeY  int
+	orientation   byte
+	font          fontSpec
+	barcode       barcodeDefaults
+	reverse       bool
+	inverted      bool
+}
+
+type renderer struct {
+	options RenderOptions
+	state   renderState
+	// The widest and longest label the printer could take, in dots
+	limit image.Point
+	label *image.Gray
+	// Where a ^FT without a position goes: just after the last field
+	nextX, nextY int
+}
+
+// Render draws a preview of each label in ZPL source
+func Render(source string, options RenderOptions) []*image.Gray {
+	return Parse(source).Render(options)
+}
+
+// Render draws a preview of each label, one dot to a pixel. Text is set in
+// stand-ins for the printer's fonts, and anything it doesn't know how to draw
+// is left off.
+func (document *Document) Render(options RenderOptions) []*image.Gray {
+	labels := make([]*image.Gray, 0, len(document.Labels))
+
+	document.renderEach(options, func(label *image.Gray) {
+		labels = append(labels, label)
+	})
+
+	return labels
+}
+
+// RenderLast draws only the last label, the one left showing on the printer,
+// or returns nil if there are no labels. Labels before it still get gone
+// through for the settings they leave behind.
+func (document *Document) RenderLast(options RenderOptions) *image.Gray {
+	var last *image.Gray
+
+	document.renderEach(options, func(label *image.Gray) {
+		last = label
+	})
+
+	return last
+}
+
+func (document *Document) renderEach(options RenderOptions, each func(*image.Gray)) {
+	if options.DPI <= 0 {
+		options.DPI = defaultDPI
+	}
+	options.DPI = clamp(options.DPI, 1, maxDPI)
+
+	renderer := &renderer{
+		options: options,
+		state: renderState{
+			width:       options.Width,
+			length:      options.Length,
+			orientation: 'N',
+			font:        newFontSpec('A', 0, 0),
+			barcode:     defaultBarcode,
+		},
+	}
+
+	renderer.limit = image.Pt(int(maxWidthInches*float64(options.DPI)), maxLengthInches*options.DPI)
+
+	if renderer.state.width <= 0 {
+		renderer.state.width = defaultWidth * options.DPI / defaultDPI
+	}
+	if renderer.state.length <= 0 {
+		renderer.state.length = defaultLength * options.DPI / defaultDPI
+	}
+
+	for _, label := range document.Labels {
+		each(renderer.render(label))
+	}
+}
+
+func (renderer *renderer) render(label *Label) *image.Gray {
+	// The label size applies to the whole label wherever it's set
+	if command := label.Find("PW"); command != nil {
+		if width, ok := command.Int(0, 0); ok && width > 0 {
+			renderer.state.width = width
+		}
+	}
+	if command := label.Find("LL"); command != nil {
+		if length, ok := command.Int(0, 0); ok && length > 0 {
+			renderer.state.length = length
+		}
+	}
+
+	renderer.state.width = clamp(renderer.state.width, 1, renderer.limit.X)
+	renderer.state.length = clamp(renderer.state.length, 1, renderer.limit.Y)
+
+	renderer.label = image.NewGray(image.Rect(0, 0, renderer.state.width, renderer.state.length))
+	for index := range renderer.label.Pix {
+		renderer.label.Pix[index] = 0xff
+	}
+
+	// Fields draw once their last command has gone by, so settings made
+	// inside them (^BY, ^FW) have taken effect
+	fieldEnds := make(map[*Command]*Field)
+	for _, field := range label.Fields {
+		fieldEnds[field.Commands[len(field.Commands)-1]] = field
+	}
+
+	for _, command := range label.Commands {
+		renderer.apply(command)
+
+		if field, ok := fieldEnds[command]; ok {
+			renderer.drawField(field)
+		}
+	}
+
+	if renderer.state.inverted {
+		rotated, _, _ := rotate(grayToAlpha(renderer.label), 0, 0, 'I')
+		return alphaToGray(rotated)
+	}
+
+	return renderer.label
+}
+
+// Take on the settings a command makes
+func (renderer *renderer) apply(command *Command) {
+	state := &renderer.state
+
+	switch command.Name {
+	case "LH":
+		state.homeX, _ = command.Int(0, state.homeX)
+		state.homeY, _ = command.Int(1, state.homeY)
+	case "FW":
+		if orientation := command.Arg(0); orientation != "" {
+			state.orientation = strings.ToUpper(orientation)[0]
+		}
+	case "CF":
+		name := state.font.name
+		if arg := command.Arg(0); arg != "" {
+			name = strings.ToUpper(arg)[0]
+		}
+
+		height, _ := command.Int(1, 0)
+		width, _ := command.Int(2, 0)
+		state.font = newFontSpec(name, height, width)
+	case "BY":
+		// Printers take module widths of 1 to 10 dots
+		module, _ := command.Int(0, state.barcode.module)
+		state.barcode.module = clamp(module, 1, 10)
+		state.barcode.height, _ = command.Int(2, state.barcode.height)
+	case "LR":
+		state.reverse = yes(command.Arg(0), false)
+	case "PO":
+		state.inverted = strings.ToUpper(command.Arg(0)) == "I"
+	}
+}
+
+// An orientation parameter, or the ^FW default if it wasn't given
+func (renderer *renderer) orientation(arg string) byte {
+	if arg == "" {
+		return renderer.state.orientation
+	}
+
+	return strings.ToUpper(arg)[0]
+}
+
+// The font a field's ^A asks for, or the ^CF default
+func (renderer *renderer) fieldFont(field *Field) (fontSpec, byte) {
+	command := field.Find("A", "A@")
+
+	if command == nil {
+		return renderer.state.font, renderer.state.orientation
+	}
+
+	height, _ := command.Int(1, 0)
+	width, _ := command.Int(2, 0)
+
+	if command.Name == "A@" {
+		return newFontSpec('0', height, width), renderer.orientation(command.Arg(0))
+	}
+
+	font := command.Arg(0)
+	if font == "" {
+		return renderer.state.font, renderer.state.orientation
+	}
+
+	// Without a size it's the size ^CF last set
+	if height == 0 && width == 0 {
+		height, width = renderer.state.font.height, renderer.state.font.width
+	}
+
+	return newFontSpec(strings.ToUpper(font)[0], height, width), renderer.orientation(font[1:])
+}
+
+func (renderer *renderer) drawField(field *Field) {
+	x, y := 0, 0
+	typeset := false
+
+	if origin := field.Origin; origin != nil {
+		typeset = origin.Name == "FT"
+
+		if typeset && origin.Arg(0) == "" && origin.Arg(1) == "" {
+			x, y = renderer.nextX, renderer.nextY
+		} else {
+			x, _ = origin.Int(0, 0)
+			y, _ = origin.Int(1, 0)
+			x, y = x+renderer.state.homeX, y+renderer.state.homeY
+		}
+	}
+
+	how := inkBlack
+	if renderer.state.reverse || field.Find("FR") != nil {
+		how = inkReverse
+	}
+
+	// How far down the mask the baseline, or bottom of the bars, is
+	var mask *image.Alpha
+	var anchorY int
+	orientation := byte('N')
+
+	if graphic := field.Find("GB", "GC", "GE", "GF"); graphic != nil {
+		var err error
+		mask, err = drawGraphic(graphic, renderer.limit)
+
+		if err != nil {
+			return
+		}
+
+		// Graphics drawn in white clear what's under them
+		if color, ok := graphicColor[graphic.Name]; ok && how == inkBlack && strings.ToUpper(graphic.Arg(color)) == "W" {
+			how = inkWhite
+		}
+
+		anchorY = mask.Bounds().Dy()
+	} else if barcode := field.Barcode(); barcode != nil {
+		if field.Data == nil {
+			return
+		}
+
+		var err error
+		orientation = renderer.orientation(barcode.Arg(0))
+
+		if _, ok := linearSymbologies[barcode.Name]; ok {
+			mask, anchorY, err = drawLinear(barcode, field.Text, renderer.state.barcode, renderer.limit)
+		} else {
+			mask, err = drawMatrix(barcode, field.Text, renderer.state.barcode, renderer.options.DPI, renderer.limit)
+		}
+
+		if err != nil {
+			return
+		}
+
+		if anchorY == 0 {
+			anchorY = mask.Bounds().Dy()
+		}
+	} else if field.Data != nil {
+		var font fontSpec
+		font, orientation = renderer.fieldFont(field)
+		font = font.within(renderer.limit.X)
+
+		if block := field.Find("FB"); block != nil {
+			width, _ := block.Int(0, 0)
+			lines, _ := block.Int(1, 1)
+			spacing, _ := block.Int(2, 0)
+			justification := byte('L')
+			if arg := block.Arg(3); arg != "" {
+				justification = strings.ToUpper(arg)[0]
+			}
+
+			mask, anchorY = font.drawBlock(field.Text, fieldBlock{width: width, lines: lines, spacing: spacing, justification: justification}, renderer.limit.Y)
+		} else {
+			mask, anchorY = font.draw(field.Text, renderer.limit.Y)
+		}
+	} else {
+		return
+	}
+
+	rotated, rotatedX, rotatedY := rotate(mask, 0, anchorY, orientation)
+	left, top := x, y
+
+	// ^FT puts the baseline (or bottom, for barcodes and graphics) at the
+	// position, ^FO the top left corner
+	if typeset {
+		left, top = x-rotatedX, y-rotatedY
+	}
+
+	renderer.stamp(rotated, left, top, how)
+
+	// The next ^FT without a position carries on from the end of this field
+	endX, endY := rotatePoint(mask.Bounds().Dx(), mask.Bounds().Dy(), mask.Bounds().Dx(), anchorY, orientation)
+	renderer.nextX, renderer.nextY = left+endX, top+endY
+}
+
+// Put a mask's ink onto the label with its top left corner at left, top
+func (renderer *renderer) stamp(mask *image.Alpha, left, top int, how ink) {
+	bounds := mask.Bounds()
+	label := renderer.label
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			point := image.Pt(left+x, top+y)
+
+			if mask.Pix[y*mask.Stride+x] < 0x80 || !point.In(label.Bounds()) {
+				continue
+			}
+
+			offset := point.Y*label.Stride + point.X
+
+			switch how {
+			case inkBlack:
+				label.Pix[offset] = 0
+			case inkWhite:
+				label.Pix[offset] = 0xff
+			case inkReverse:
+				if label.Pix[offset] < 0x80 {
+					label.Pix[offset] = 0xff
+				} else {
+					label.Pix[offset] = 0
+				}
+			}
+		}
+	}
+}
+
+// Turn a mask to an orientation: N is as drawn, R 90° clockwise, I upside
+// down and B 90° counterclockwise. A point in the mask is carried along.
+func rotate(mask *image.Alpha, anchorX, anchorY int, orientation byte) (*image.Alpha, int, int) {
+	width, height := mask.Bounds().Dx(), mask.Bounds().Dy()
+	var rotated *image.Alpha
+
+	switch orientation {
+	case 'R', 'B':
+		rotated = image.NewAlpha(image.Rect(0, 0, height, width))
+	case 'I':
+		rotated = image.NewAlpha(image.Rect(0, 0, width, height))
+	default:
+		return mask, anchorX, anchorY
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := mask.Pix[y*mask.Stride+x]
+
+			switch orientation {
+			case 'R':
+				rotated.Pix[x*rotated.Stride+height-1-y] = value
+			case 'I':
+				rotated.Pix[(height-1-y)*rotated.Stride+width-1-x] = value
+			case 'B':
+				rotated.Pix[(width-1-x)*rotated.Stride+y] = value
+			}
+		}
+	}
+
+	anchorX, anchorY = rotatePoint(width, height, anchorX, anchorY, orientation)
+
+	return rotated, anchorX, anchorY
+}
+
+// Where a point in a width by height mask ends up when it's rotated
+func rotatePoint(width, height, x, y int, orientation byte) (int, int) {
+	switch orientation {
+	case 'R':
+		return height - y, x
+	case 'I':
+		return width - x, height - y
+	case 'B':
+		return y, width - x
+	}
+
+	return x, y
+}
+
+func grayToAlpha(gray *image.Gray) *image.Alpha {
+	alpha := image.NewAlpha(gray.Bounds())
+
+	for index, value := range gray.Pix {
+		alpha.Pix[index] = 0xff - value
+	}
+
+	return alpha
+}
+
+func alphaToGray(alpha *image.Alpha) *image.Gray {
+	gray := image.NewGray(alpha.Bounds())
+
+	for index, value := range alpha.Pix {
+		gray.Pix[index] = 0xff - value
+	}
+
+	return gray
+}
+
+// Which parameter of each graphic command is its line color
+var graphicColor = map[string]int{
+	"GB": 3,
+	"GC": 2,
+	"GE": 3,
+}
+
+// Draw a ^GB box, ^GC circle, ^GE ellipse or ^GF image, cut down to fit
+// within limit
+func drawGraphic(command *Command, limit image.Point) (*image.Alpha, error) {
+	switch command.Name {
+	case "GB":
+		thickness, _ := command.Int(2, 1)
+		width, _ := command.Int(0, thickness)
+		height, _ := command.Int(1, thickness)
+		rounding, _ := command.Int(4, 0)
+
+		thickness = clamp(thickness, 1, limit.X)
+		width = clamp(width, thickness, limit.X)
+		height = clamp(height, thickness, limit.Y)
+
+		return drawBox(width, height, thickness, rounding), nil
+	case "GC":
+		diameter, _ := command.Int(0, 3)
+		thickness, _ := command.Int(1, 1)
+		diameter = clamp(diameter, 0, limit.X)
+
+		return drawEllipse(diameter, diameter, thickness), nil
+	case "GE":
+		width, _ := command.Int(0, 3)
+		height, _ := command.Int(1, 3)
+		thickness, _ := command.Int(2, 1)
+
+		return drawEllipse(clamp(width, 0, limit.X), clamp(height, 0, limit.Y), thickness), nil
+	case "GF":
+		return decodeGraphic(command, limit)
+	}
+
+	return nil, errors.New("Not a graphic")
+}
+
+// A box with lines thickness dots wide; rounding (0-8) eats into the corners
+// up to half the shorter side
+func drawBox(width, height, thickness, rounding int) *image.Alpha {
+	if thickness < 1 {
+		thickness = 1
+	}
+	if width < thickness {
+		width = thickness
+	}
+	if height < thickness {
+		height = thickness
+	}
+
+	radius := float64(rounding) / 8 * math.Min(float64(width), float64(height)) / 2
+	inner := math.Max(radius-float64(thickness), 0)
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+
+			outside := !inRoundedRect(px, py, 0, 0, float64(width), float64(height), radius)
+			hollow := inRoundedRect(px, py, float64(thickness), float64(thickness), float64(width-thickness), float64(height-thickness), inner)
+
+			if !outside && !hollow {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+
+	return mask
+}
+
+func inRoundedRect(x, y, left, top, right, bottom, radius float64) bool {
+	if x < left || x > right || y < top || y > bottom {
+		return false
+	}
+
+	// Only the corners are rounded off
+	cornerX := math.Max(left+radius-x, x-(right-radius))
+	cornerY := math.Max(top+radius-y, y-(bottom-radius))
+
+	if cornerX > 0 && cornerY > 0 {
+		return math.Hypot(cornerX, cornerY) <= radius
+	}
+
+	return true
+}
+
+// An ellipse width by height with a line thickness dots wide
+func drawEllipse(width, height, thickness int) *image.Alpha {
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	radiusX, radiusY := float64(width)/2, float64(height)/2
+	innerX, innerY := radiusX-float64(thickness), radiusY-float64(thickness)
+
+	inside := func(x, y, radiusX, radiusY float64) bool {
+		if radiusX <= 0 || radiusY <= 0 {
+			return false
+		}
+
+		return (x*x)/(radiusX*radiusX)+(y*y)/(radiusY*radiusY) <= 1
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px, py := float64(x)+0.5-radiusX, float64(y)+0.5-radiusY
+
+			if inside(px, py, radiusX, radiusY) && !inside(px, py, innerX, innerY) {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+
+	return mask
+}
+
+// Decode ^GF image data: ASCII hex (with the printer's run length
+// compression, or :B64:/:Z64: encoded) or raw binary, a bit to a dot. Rows
+// past the end of the longest label are left off.
+func decodeGraphic(command *Command, limit image.Point) (*image.Alpha, error) {
+	format := strings.ToUpper(command.Arg(0))
+	total, _ := command.Int(2, 0)
+	row, _ := command.Int(3, 0)
+
+	if row <= 0 || len(command.Args) < 5 {
+		return nil, errors.New("^GF needs the bytes per row and data")
+	} else if row*8 > limit.X+7 {
+		return nil, errors.New("^GF is wider than any label")
+	}
+
+	data := strings.Join(command.Args[4:], ",")
+	var bitmap []byte
+	var err error
+
+	switch format {
+	case "", "A":
+		bitmap, err = decodeGraphicASCII(data, row, limit.Y)
+	case "B":
+		bitmap = []byte(data)
+	default:
+		err = errors.New("Compressed binary ^GF isn't supported")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if total > 0 && total < len(bitmap) {
+		bitmap = bitmap[:total]
+	}
+
+	height := clamp(len(bitmap)/row, 0, limit.Y)
+	mask := image.NewAlpha(image.Rect(0, 0, row*8, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < row*8; x++ {
+			if bitmap[y*row+x/8]&(0x80>>uint(x%8)) != 0 {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+
+	return mask, nil
+}
+
+func decodeGraphicASCII(data string, row int, maxRows int) ([]byte, error) {
+	for _, prefix := range []string{":B64:", ":Z64:"} {
+		if !strings.HasPrefix(data, prefix) {
+			continue
+		}
+
+		// The encoded data is followed by :CRC
+		encoded := strings.TrimPrefix(data, prefix)
+		if colon := strings.Index(encoded, ":"); colon >= 0 {
+			encoded = encoded[:colon]
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+
+		if err != nil || prefix == ":B64:" {
+			return decoded, err
+		}
+
+		reader, err := zlib.NewReader(bytes.NewReader(decoded))
+
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return ioutil.ReadAll(io.LimitReader(reader, int64(row*maxRows)))
+	}
+
+	// Hex digits, where G-Y repeat the next digit 1-19 times and g-z 20-400
+	// times, a comma fills the rest of the row with 0s, ! with 1s and : repeats
+	// the row before
+	rowDigits := row * 2
+	var rows []string
+	var current strings.Builder
+	repeat := 0
+
+	finishRow := func(fill byte) {
+		line := current.String()
+		if len(line) < rowDigits {
+			line += strings.Repeat(string(fill), rowDigits-len(line))
+		}
+
+		rows = append(rows, line[:rowDigits])
+		current.Reset()
+	}
+
+	for index := 0; index < len(data) && len(rows) < maxRows; index++ {
+		char := data[index]
+
+		switch {
+		case char >= 'G' && char <= 'Y':
+			repeat += int(char-'G') + 1
+		case char >= 'g' && char <= 'z':
+			repeat += (int(char-'g') + 1) * 20
+		case char == ',':
+			finishRow('0')
+		case char == '!':
+			finishRow('F')
+		case char == ':':
+			if len(rows) > 0 {
+				rows = append(rows, rows[len(rows)-1])
+			}
+		case strings.IndexByte("0123456789ABCDEFabcdef", char) >= 0:
+			if repeat == 0 {
+				repeat = 1
+			}
+
+			// A row at a time, stopping once there are all the rows there can be
+			for repeat > 0 && len(rows) < maxRows {
+				count := clamp(repeat, 0, rowDigits-current.Len())
+				current.WriteString(strings.Repeat(string(char), count))
+				repeat -= count
+
+				if current.Len() == rowDigits {
+					rows = append(rows, current.String())
+					current.Reset()
+				}
+			}
+			repeat = 0
+		}
+	}
+
+	if current.Len() > 0 && len(rows) < maxRows {
+		finishRow('0')
+	}
+
+	if len(rows) > maxRows {
+		rows = rows[:maxRows]
+	}
+
+	return hex.DecodeString(strings.Join(rows, ""))
+}
+
+// Keep a value between low and high
+func clamp(value, low, high int) int {
+	if value < low {
+		return low
+	} else if value > high {
+		return high
+	}
+
+	return value
+}
@@ -0,0 +1,185 @@
+package zpl
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+// How many dots of the label are black
+func inked(label *image.Gray) int {
+	count := 0
+
+	for _, value := range label.Pix {
+		if value < 128 {
+			count++
+		}
+	}
+
+	return count
+}
+
+func renderOne(t *testing.T, source string, options RenderOptions) *image.Gray {
+	t.Helper()
+
+	labels := Render(source, options)
+
+	if len(labels) != 1 {
+		t.Fatalf("%.60q: got %v labels, want 1", source, len(labels))
+	}
+
+	return labels[0]
+}
+
+func TestRenderZeroSizes(t *testing.T) {
+	cases := []string{
+		"^XA^CFZ^FO10,10^FDx^FS^XZ",
+		"^XA^CF0,0,0^FO10,10^FDx^FS^XZ",
+		"^XA^FO10,10^A0N,0,0^FDx^FS^XZ",
+		"^XA^FO10,10^A0N,0,40^FDx^FS^XZ",
+		"^XA^FO10,10^ADN,0,0^FDx^FS^XZ",
+		"^XA^BY0^FO10,10^BCN,50,Y^FD123^FS^XZ",
+		"^XA^BY0,0,0^FO10,10^B3N,N,0,Y^FD123^FS^XZ",
+		"^XA^FO10,10^BQN,2,0^FDQA,123^FS^XZ",
+		"^XA^FO10,10^BXN,0,200^FD123^FS^XZ",
+		"^XA^FO10,10^GB0,0,0^FS^XZ",
+		"^XA^FO10,10^GC0,0^FS^XZ",
+		"^XA^FO10,10^GE0,0,0^FS^XZ",
+		"^XA^FO10,10^GFA,0,0,0,^FS^XZ",
+		"^XA^FO10,10^FB0,0,0^FDwrap me^FS^XZ",
+	}
+
+	for _, source := range cases {
+		renderOne(t, source, RenderOptions{})
+	}
+}
+
+func TestRenderZeroSizesStillDraw(t *testing.T) {
+	cases := []string{
+		"^XA^CFZ^FO10,10^FDxyz^FS^XZ",
+		"^XA^FO10,10^A0N,0,0^FDxyz^FS^XZ",
+		"^XA^BY0^FO10,10^BCN,50,Y^FD123^FS^XZ",
+	}
+
+	for _, source := range cases {
+		if label := renderOne(t, source, RenderOptions{}); inked(label) == 0 {
+			t.Errorf("%q: nothing was drawn", source)
+		}
+	}
+}
+
+func TestRenderHugeSizes(t *testing.T) {
+	options := RenderOptions{DPI: defaultDPI}
+	limit := image.Pt(int(maxWidthInches*float64(options.DPI)), maxLengthInches*options.DPI)
+
+	cases := []string{
+		"^XA^PW999999999^LL999999999^XZ",
+		"^XA^FO0,0^GB999999999,999999999,5^FS^XZ",
+		"^XA^FO0,0^GB999999999,999999999,999999999^FS^XZ",
+		"^XA^FO0,0^GC999999999,2^FS^XZ",
+		"^XA^FO0,0^GE999999999,999999999,2^FS^XZ",
+		"^XA^FO0,0^GFA,999999999,999999999,100,FF^FS^XZ",
+		"^XA^FO0,0^GFA,100,100,999999999,FF^FS^XZ",
+		"^XA^FO0,0^GFA,20000,20000,10,:::::::::::::::::::::::::::::::::::^FS^XZ",
+		"^XA^FO0,0^A0N,999999,999999^FDW^FS^XZ",
+		"^XA^FO0,0^A0N,50,50^FD" + strings.Repeat("W", 20000) + "^FS^XZ",
+		"^XA^FO0,0^A0N,50,50^FB999999999,999999,999999^FD" + strings.Repeat("a b ", 200) + "^FS^XZ",
+		"^XA^BY999^FO0,0^BCN,999999999,Y^FD" + strings.Repeat("1", 2000) + "^FS^XZ",
+		"^XA^FO0,0^BQN,2,999999^FDQA,hello^FS^XZ",
+		"^XA^FO0,0^BXN,999999,200^FDhello^FS^XZ",
+	}
+
+	for _, source := range cases {
+		for _, orientation := range []string{"", "^FWR"} {
+			label := renderOne(t, strings.Replace(source, "^XA", "^XA^PW999999^LL999999"+orientation, 1), options)
+
+			if size := label.Bounds().Size(); size.X > limit.X || size.Y > limit.Y {
+				t.Errorf("%.60q: label is %v, bigger than %v", source, size, limit)
+			}
+		}
+	}
+}
+
+func TestRenderHugeDPI(t *testing.T) {
+	label := renderOne(t, "^XA^FO0,0^FDx^FS^XZ", RenderOptions{DPI: 999999, Width: 999999999, Length: 999999999})
+	dpi := maxDPI
+
+	if size := label.Bounds().Size(); size.X > int(maxWidthInches*float64(dpi)) || size.Y > maxLengthInches*dpi {
+		t.Errorf("label is %v, bigger than the biggest printer takes", size)
+	}
+}
+
+func TestRenderModuleWidths(t *testing.T) {
+	cases := []struct {
+		module string
+		want   string
+	}{
+		{"0", "1"},
+		{"-3", "1"},
+		{"11", "10"},
+		{"999", "10"},
+	}
+
+	for _, test := range cases {
+		got := renderOne(t, "^XA^BY"+test.module+"^FO10,10^BCN,50,N^FD123^FS^XZ", RenderOptions{})
+		want := renderOne(t, "^XA^BY"+test.want+"^FO10,10^BCN,50,N^FD123^FS^XZ", RenderOptions{})
+
+		if string(got.Pix) != string(want.Pix) {
+			t.Errorf("^BY%v draws differently than ^BY%v", test.module, test.want)
+		}
+	}
+}
+
+func TestRenderPrefixChanges(t *testing.T) {
+	want := renderOne(t, "^XA^FO10,10^GB100,50,3^FS^FO10,80^A0N,30,30^FDhi,there^FS^XZ", RenderOptions{})
+
+	cases := []string{
+		"^CC!!XA!FO10,10!GB100,50,3!FS!FO10,80!A0N,30,30!FDhi,there!FS!XZ",
+		"~CC!!XA!FO10,10!GB100,50,3!FS!FO10,80!A0N,30,30!FDhi,there!FS!XZ",
+		"^XA^CD;^FO10;10^GB100;50;3^FS^FO10;80^A0N;30;30^FDhi,there^FS^XZ",
+		"~CT+^XA^FO10,10^GB100,50,3^FS^FO10,80^A0N,30,30^FDhi,there^FS^XZ",
+	}
+
+	for _, source := range cases {
+		got := renderOne(t, source, RenderOptions{})
+
+		if got.Bounds() != want.Bounds() || string(got.Pix) != string(want.Pix) {
+			t.Errorf("%q draws differently than with the default prefixes", source)
+		}
+	}
+}
+
+func TestRenderGraphicField(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		// Rows of the 16x3 dot graphic, X for black
+		want []string
+	}{
+		{"hex", "F00F\nFFFF\n0000", []string{"XXXX........XXXX", "XXXXXXXXXXXXXXXX", "................"}},
+		{"repeat counts", "JF\nHFHF\n,", []string{"XXXXXXXXXXXXXXXX", "XXXXXXXXXXXXXXXX", "................"}},
+		{"repeated row", "0F0F\n:\n!", []string{"....XXXX....XXXX", "....XXXX....XXXX", "XXXXXXXXXXXXXXXX"}},
+		{"malformed", "Fz\n", []string{"XXXX............", "................", "................"}},
+	}
+
+	for _, test := range cases {
+		label := renderOne(t, "^XA^PW16^LL3^FO0,0^GFA,6,6,2,"+test.data+"^FS^XZ", RenderOptions{})
+
+		var got []string
+		for y := 0; y < 3; y++ {
+			var row strings.Builder
+			for x := 0; x < 16; x++ {
+				if label.GrayAt(x, y).Y < 128 {
+					row.WriteByte('X')
+				} else {
+					row.WriteByte('.')
+				}
+			}
+			got = append(got, row.String())
+		}
+
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package zpl
+
+import (
+	"image"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Height and width in dots of the printer's bitmap fonts; anything else
+// (0, and fonts downloaded to the printer) is drawn as a scalable font
+var bitmapFonts = map[byte][2]int{
+	'A': {9, 5},
+	'B': {11, 7},
+	'C': {18, 10},
+	'D': {18, 10},
+	'E': {28, 15},
+	'F': {26, 13},
+	'G': {60, 40},
+	'H': {21, 13},
+}
+
+// How wide a monospaced character is for its size
+const monoAdvance = 0.6
+
+// How tall a scalable font comes out when nothing gives it a size, as ^CF's default
+const defaultFontHeight = 9
+
+// A font at the size a field asked for, in dots
+type fontSpec struct {
+	name   byte
+	height int
+	width  int
+}
+
+// Size a font the way the printer would from ^A or ^CF parameters, 0 for
+// ones that weren't given; bitmap fonts only come in whole multiples
+func newFontSpec(name byte, height, width int) fontSpec {
+	if nominal, ok := bitmapFonts[name]; ok {
+		magnify := func(given, size int) int {
+			return int(math.Max(1, math.Round(float64(given)/float64(size))))
+		}
+
+		switch {
+		case height <= 0 && width <= 0:
+			height, width = nominal[0], nominal[1]
+		case height <= 0:
+			height = nominal[0] * magnify(width, nominal[1])
+			width = nominal[1] * magnify(width, nominal[1])
+		case width <= 0:
+			width = nominal[1] * magnify(height, nominal[0])
+		}
+	} else if height <= 0 && width <= 0 {
+		height, width = defaultFontHeight, defaultFontHeight
+	} else if height <= 0 {
+		height = width
+	} else if width <= 0 {
+		width = height
+	}
+
+	return fontSpec{name: name, height: height, width: width}
+}
+
+// The font no taller or wider than largest dots
+func (spec fontSpec) within(largest int) fontSpec {
+	spec.height = clamp(spec.height, 1, largest)
+	spec.width = clamp(spec.width, 1, largest)
+
+	return spec
+}
+
+func (spec fontSpec) bitmap() bool {
+	_, ok := bitmapFonts[spec.name]
+	return ok
+}
+
+// The stand-ins for the printer's fonts: bitmap fonts are monospaced
+type typeface struct {
+	font *opentype.Font
+	// Ascent and height of a line at size 1
+	ascent float64
+	height float64
+}
+
+var (
+	loadTypefaces sync.Once
+	scalableFace  typeface
+	monoFace      typeface
+)
+
+func newTypeface(ttf []byte) typeface {
+	parsed, err := opentype.Parse(ttf)
+
+	if err != nil {
+		panic(err)
+	}
+
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: 100, DPI: 72})
+
+	if err != nil {
+		panic(err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+
+	return typeface{
+		font:   parsed,
+		ascent: float64(metrics.Ascent.Ceil()) / 100,
+		height: float64((metrics.Ascent + metrics.Descent).Ceil()) / 100,
+	}
+}
+
+func (spec fontSpec) typeface() typeface {
+	loadTypefaces.Do(func() {
+		scalableFace = newTypeface(gobold.TTF)
+		monoFace = newTypeface(gomonobold.TTF)
+	})
+
+	if spec.bitmap() {
+		return monoFace
+	}
+
+	return scalableFace
+}
+
+// The font's face, size times scale
+func (spec fontSpec) face(scale float64) (font.Face, error) {
+	typeface := spec.typeface()
+	size := float64(spec.height) / typeface.height * scale
+
+	return opentype.NewFace(typeface.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// How wide text drawn natural dots wide comes out once it's stretched to the
+// font's width. Characters in bitmap fonts are exactly the font width apart;
+// scalable fonts stretch in proportion to their width against their height.
+func (spec fontSpec) stretchedWidth(natural int) int {
+	if spec.bitmap() {
+		size := float64(spec.height) / spec.typeface().height
+		return int(float64(natural) * float64(spec.width) / (monoAdvance * size))
+	}
+
+	return natural * spec.width / spec.height
+}
+
+// As much of the start of text as fits in width dots drawn in face
+func textWithin(face font.Face, text string, width int) string {
+	limit := fixed.I(width)
+	var advance fixed.Int26_6
+	previous := rune(-1)
+
+	for index, char := range text {
+		if previous >= 0 {
+			advance += face.Kern(previous, char)
+		}
+
+		if advance > limit {
+			return text[:index]
+		}
+
+		glyphAdvance, _ := face.GlyphAdvance(char)
+		advance += glyphAdvance
+		previous = char
+	}
+
+	return text
+}
+
+// Draw a line of text a font's height tall, stretched to the font's width
+// and cut off at maxWidth. Returns the ink and how far down the baseline is.
+func (spec fontSpec) draw(text string, maxWidth int) (*image.Alpha, int) {
+	face, err := spec.face(1)
+
+	if err != nil || text == "" {
+		return image.NewAlpha(image.Rect(0, 0, 0, spec.height)), spec.height
+	}
+	defer face.Close()
+
+	natural := font.MeasureString(face, text).Ceil()
+	width := spec.stretchedWidth(natural)
+
+	// Only what fits gets drawn
+	if width > maxWidth {
+		natural = natural * maxWidth / width
+		width = maxWidth
+		text = textWithin(face, text, natural)
+	}
+
+	// Text squeezed narrower than it draws gets drawn smaller to begin with,
+	// so the drawing is never wider than maxWidth either
+	scale := 1.0
+	if natural > maxWidth {
+		scale = float64(maxWidth) / float64(natural)
+		natural = maxWidth
+
+		face, err = spec.face(scale)
+
+		if err != nil {
+			return image.NewAlpha(image.Rect(0, 0, 0, spec.height)), spec.height
+		}
+		defer face.Close()
+	}
+
+	drawHeight := int(math.Max(1, math.Round(float64(spec.height)*scale)))
+	ascent := int(math.Round(spec.typeface().ascent * float64(spec.height) / spec.typeface().height * scale))
+
+	drawer := font.Drawer{
+		Dst:  image.NewAlpha(image.Rect(0, 0, natural, drawHeight)),
+		Src:  image.Opaque,
+		Face: face,
+		Dot:  fixed.P(0, ascent),
+	}
+	drawer.DrawString(text)
+
+	return stretch(drawer.Dst.(*image.Alpha), width, spec.height), ascent * spec.height / drawHeight
+}
+
+// How wide text comes out in a font, up to maxWidth
+func (spec fontSpec) measure(text string, maxWidth int) int {
+	face, err := spec.face(1)
+
+	if err != nil || text == "" {
+		return 0
+	}
+	defer face.Close()
+
+	return clamp(spec.stretchedWidth(font.MeasureString(face, text).Ceil()), 0, maxWidth)
+}
+
+// Resize a mask to width by height, nearest neighbor
+func stretch(mask *image.Alpha, width, height int) *image.Alpha {
+	bounds := mask.Bounds()
+
+	if bounds.Dx() == width && bounds.Dy() == height {
+		return mask
+	}
+
+	stretched := image.NewAlpha(image.Rect(0, 0, width, height))
+
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return stretched
+	}
+
+	for y := 0; y < height; y++ {
+		fromY := y * bounds.Dy() / height
+
+		for x := 0; x < width; x++ {
+			stretched.Pix[y*stretched.Stride+x] = mask.Pix[fromY*mask.Stride+x*bounds.Dx()/width]
+		}
+	}
+
+	return stretched
+}
+
+// A ^FB field block: text wrapped to a width over a number of lines
+type fieldBlock struct {
+	width         int
+	lines         int
+	spacing       int
+	justification byte
+}
+
+// Draw text wrapped into a field block, no wider or taller than maxSize.
+// Returns the ink and the baseline of the last line.
+func (spec fontSpec) drawBlock(text string, block fieldBlock, maxSize int) (*image.Alpha, int) {
+	block.width = clamp(block.width, 0, maxSize)
+	pitch := clamp(spec.height+block.spacing, 1, maxSize)
+
+	// Lines past the bottom of the longest label wouldn't be seen
+	if most := (maxSize-spec.height)/pitch + 1; block.lines <= 0 || block.lines > most {
+		block.lines = most
+	}
+
+	var lines []string
+
+	// \& is a line break in a field block
+	for _, paragraph := range strings.Split(text, `\&`) {
+		line := ""
+
+		for _, word := range strings.Fields(paragraph) {
+			candidate := strings.TrimSpace(line + " " + word)
+
+			if line != "" && block.width > 0 && spec.measure(candidate, maxSize) > block.width {
+				lines = append(lines, line)
+				line = word
+
+				if len(lines) >= block.lines {
+					break
+				}
+			} else {
+				line = candidate
+			}
+		}
+
+		lines = append(lines, line)
+
+		if len(lines) >= block.lines {
+			break
+		}
+	}
+
+	if len(lines) > block.lines {
+		lines = lines[:block.lines]
+	}
+
+	width := block.width
+	if width <= 0 {
+		for _, line := range lines {
+			if lineWidth := spec.measure(line, maxSize); lineWidth > width {
+				width = lineWidth
+			}
+		}
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, pitch*(len(lines)-1)+spec.height))
+	ascent := spec.height
+
+	for index, line := range lines {
+		lineMask, lineAscent := spec.draw(line, maxSize)
+		ascent = lineAscent
+
+		left := 0
+		switch block.justification {
+		case 'C':
+			left = (width - lineMask.Bounds().Dx()) / 2
+		case 'R':
+			left = width - lineMask.Bounds().Dx()
+		}
+
+		overlay(mask, lineMask, left, index*pitch)
+	}
+
+	return mask, pitch*(len(lines)-1) + ascent
+}
+
+// Add a mask's ink into a bigger one at left, top
+func overlay(into *image.Alpha, mask *image.Alpha, left, top int) {
+	bounds := mask.Bounds()
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			point := image.Pt(left+x, top+y)
+
+			if !point.In(into.Bounds()) {
+				continue
+			}
+
+			if value := mask.Pix[y*mask.Stride+x]; value > into.Pix[point.Y*into.Stride+point.X] {
+				into.Pix[point.Y*into.Stride+point.X] = value
+			}
+		}
+	}
+}